@@ -4,8 +4,6 @@
 package main
 
 import (
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -13,7 +11,9 @@ import (
 
 	"github.com/norunners/vert"
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/loc"
 	"github.com/withastro/compiler/internal/printer"
+	"github.com/withastro/compiler/internal/sourcemap"
 	"github.com/withastro/compiler/internal/transform"
 	wasm_utils "github.com/withastro/compiler/internal_wasm/utils"
 	"golang.org/x/net/html/atom"
@@ -23,6 +23,7 @@ var done chan bool
 
 func main() {
 	js.Global().Set("__astro_transform", Transform())
+	js.Global().Set("__astro_analyzeStyles", AnalyzeStyles())
 	// This ensures that the WASM doesn't exit early
 	<-make(chan bool)
 }
@@ -82,35 +83,330 @@ func makeTransformOptions(options js.Value, hash string) transform.TransformOpti
 		staticExtraction = true
 	}
 
+	staticScriptExtraction := false
+	if jsBool(options.Get("experimentalStaticScriptExtraction")) {
+		staticScriptExtraction = true
+	}
+
+	staticSubtrees := false
+	if jsBool(options.Get("experimentalStaticSubtrees")) {
+		staticSubtrees = true
+	}
+
+	resolveStyleImports := false
+	if jsBool(options.Get("resolveStyleImports")) {
+		resolveStyleImports = true
+	}
+
+	scopeStyleImports := false
+	if jsBool(options.Get("scopeStyleImports")) {
+		scopeStyleImports = true
+	}
+
+	scopeSVGStyles := false
+	if jsBool(options.Get("scopeSVGStyles")) {
+		scopeSVGStyles = true
+	}
+
+	resolveSpecifiers := false
+	if jsBool(options.Get("resolveSpecifiers")) {
+		resolveSpecifiers = true
+	}
+
+	mode := jsString(options.Get("mode"))
+	if mode == "" {
+		mode = "compile"
+	}
+
 	preprocessStyle := options.Get("preprocessStyle")
+	componentName := jsString(options.Get("componentName"))
 
 	return transform.TransformOptions{
-		As:               as,
-		Scope:            hash,
-		Filename:         filename,
-		Pathname:         pathname,
-		InternalURL:      internalURL,
-		SourceMap:        sourcemap,
-		Site:             site,
-		ProjectRoot:      projectRoot,
-		PreprocessStyle:  preprocessStyle,
-		StaticExtraction: staticExtraction,
+		As:                     as,
+		Scope:                  hash,
+		Filename:               filename,
+		Pathname:               pathname,
+		InternalURL:            internalURL,
+		SourceMap:              sourcemap,
+		Site:                   site,
+		ProjectRoot:            projectRoot,
+		PreprocessStyle:        preprocessStyle,
+		StaticExtraction:       staticExtraction,
+		StaticScriptExtraction: staticScriptExtraction,
+		StaticSubtrees:         staticSubtrees,
+		ResolveStyleImports:    resolveStyleImports,
+		ScopeStyleImports:      scopeStyleImports,
+		ScopeSVGStyles:         scopeSVGStyles,
+		Mode:                   mode,
+		ResolveSpecifiers:      resolveSpecifiers,
+		ComponentName:          componentName,
 	}
 }
 
-type RawSourceMap struct {
-	File           string   `js:"file"`
-	Mappings       string   `js:"mappings"`
-	Names          []string `js:"names"`
-	Sources        []string `js:"sources"`
-	SourcesContent []string `js:"sourcesContent"`
-	Version        int      `js:"version"`
+type StyleImport struct {
+	Specifier string `js:"specifier"`
+	Media     string `js:"media"`
+	Start     int    `js:"start"`
+	Resolved  bool   `js:"resolved"`
+}
+
+type Diagnostic struct {
+	Severity string `js:"severity"`
+	Text     string `js:"text"`
+	Start    int    `js:"start"`
+	End      int    `js:"end"`
+}
+
+type ModuleImport struct {
+	Specifier string `js:"specifier"`
+	Kind      string `js:"kind"`
+	Start     int    `js:"start"`
+	End       int    `js:"end"`
 }
 
 type TransformResult struct {
-	Code string   `js:"code"`
-	Map  string   `js:"map"`
-	CSS  []string `js:"css"`
+	Code         string            `js:"code"`
+	Map          string            `js:"map"`
+	CSS          []string          `js:"css"`
+	StyleImports []StyleImport     `js:"styleImports"`
+	Imports      []ModuleImport    `js:"imports"`
+	Analysis     ComponentAnalysis `js:"analysis"`
+	Scripts      []HoistedScript   `js:"scripts"`
+	Diagnostics  []Diagnostic      `js:"diagnostics"`
+}
+
+// A hoisted script, in the same order as `$$metadata.hoisted`
+type HoistedScript struct {
+	Type  string                 `js:"type"`
+	Src   string                 `js:"src"`
+	Lang  string                 `js:"lang"`
+	Attrs map[string]interface{} `js:"attrs"`
+	Code  string                 `js:"code"`
+	// Only set for inline scripts, when source maps are enabled
+	Map string `js:"map"`
+}
+
+// Like wasm_utils.GetAttrs, but as a Go value. Hoisted scripts are bundled
+// rather than rendered, so attributes whose value is an expression, a spread
+// or a template literal can't be evaluated and are left out.
+func makeAttrs(attrs []astro.Attribute) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, attr := range attrs {
+		switch attr.Type {
+		case astro.QuotedAttribute:
+			values[attr.Key] = attr.Val
+		case astro.EmptyAttribute:
+			values[attr.Key] = true
+		}
+	}
+	return values
+}
+
+func makeHoistedScripts(source string, scripts []printer.HoistedScript, transformOptions transform.TransformOptions) []HoistedScript {
+	hoisted := make([]HoistedScript, 0, len(scripts))
+	withMaps := false
+	switch transformOptions.SourceMap {
+	case "external", "both", "inline":
+		withMaps = true
+	}
+	for i, script := range scripts {
+		h := HoistedScript{Type: script.Type, Src: script.Src, Lang: script.Lang, Attrs: makeAttrs(script.Attrs), Code: string(script.Code)}
+		if script.Type == "inline" && withMaps {
+			sourcemapJSON := sourcemap.JSONSourceMap{
+				// The map describes the module the bundler loads the script as
+				File:           printer.ScriptModuleID(transformOptions.Filename, i, script.Lang),
+				Sources:        append([]string{transformOptions.Filename}, script.SourceMapChunk.Sources...),
+				SourcesContent: append([]string{source}, script.SourceMapChunk.SourcesContent...),
+				Names:          script.SourceMapChunk.Names,
+				Mappings:       script.SourceMapChunk.Buffer,
+			}
+			h.Map = string(sourcemapJSON.Marshal())
+		}
+		hoisted = append(hoisted, h)
+	}
+	return hoisted
+}
+
+func makeStyleImports(imports []astro.StyleImport) []StyleImport {
+	styleImports := make([]StyleImport, 0, len(imports))
+	for _, imported := range imports {
+		styleImports = append(styleImports, StyleImport{
+			Specifier: imported.Specifier,
+			Media:     imported.Media,
+			Start:     imported.Loc.Start,
+			Resolved:  imported.Resolved,
+		})
+	}
+	return styleImports
+}
+
+func makeImports(analysis transform.ComponentAnalysis) []ModuleImport {
+	imports := make([]ModuleImport, 0, len(analysis.Imports))
+	for _, imported := range analysis.Imports {
+		imports = append(imports, ModuleImport{
+			Specifier: imported.Specifier,
+			Kind:      imported.Kind,
+			Start:     imported.Start,
+			End:       imported.End,
+		})
+	}
+	return imports
+}
+
+type ImportBinding struct {
+	Imported string `js:"imported"`
+	Local    string `js:"local"`
+}
+
+type ImportAnalysis struct {
+	Specifier string          `js:"specifier"`
+	Kind      string          `js:"kind"`
+	Bindings  []ImportBinding `js:"bindings"`
+	Start     int             `js:"start"`
+	End       int             `js:"end"`
+	Resolved  string          `js:"resolved"`
+}
+
+type ExportAnalysis struct {
+	Name  string `js:"name"`
+	Start int    `js:"start"`
+}
+
+type ComponentUsage struct {
+	Name       string   `js:"name"`
+	Start      int      `js:"start"`
+	Directives []string `js:"directives"`
+}
+
+type ComponentAnalysis struct {
+	Imports           []ImportAnalysis `js:"imports"`
+	Exports           []ExportAnalysis `js:"exports"`
+	Components        []ComponentUsage `js:"components"`
+	Slots             []string         `js:"slots"`
+	HasGetStaticPaths bool             `js:"hasGetStaticPaths"`
+}
+
+func makeComponentAnalysis(analysis transform.ComponentAnalysis) ComponentAnalysis {
+	result := ComponentAnalysis{
+		Imports:           make([]ImportAnalysis, 0, len(analysis.Imports)),
+		Exports:           make([]ExportAnalysis, 0, len(analysis.Exports)),
+		Components:        make([]ComponentUsage, 0, len(analysis.Components)),
+		Slots:             analysis.Slots,
+		HasGetStaticPaths: analysis.HasGetStaticPaths,
+	}
+	for _, imported := range analysis.Imports {
+		bindings := make([]ImportBinding, 0, len(imported.Bindings))
+		for _, binding := range imported.Bindings {
+			bindings = append(bindings, ImportBinding{Imported: binding.Imported, Local: binding.Local})
+		}
+		result.Imports = append(result.Imports, ImportAnalysis{
+			Specifier: imported.Specifier,
+			Kind:      imported.Kind,
+			Bindings:  bindings,
+			Start:     imported.Start,
+			End:       imported.End,
+			Resolved:  imported.Resolved,
+		})
+	}
+	for _, exported := range analysis.Exports {
+		result.Exports = append(result.Exports, ExportAnalysis{Name: exported.Name, Start: exported.Start})
+	}
+	for _, component := range analysis.Components {
+		result.Components = append(result.Components, ComponentUsage{Name: component.Name, Start: component.Start, Directives: component.Directives})
+	}
+	return result
+}
+
+type StyleLocation struct {
+	Filename string `js:"filename"`
+	Start    int    `js:"start"`
+}
+
+type DuplicateStyle struct {
+	Hash      string          `js:"hash"`
+	Locations []StyleLocation `js:"locations"`
+}
+
+type GlobalSelector struct {
+	Selector   string   `js:"selector"`
+	Components []string `js:"components"`
+}
+
+type ScopeCollision struct {
+	Scope      string   `js:"scope"`
+	Components []string `js:"components"`
+}
+
+type StyleAnalysisResult struct {
+	DuplicateStyles []DuplicateStyle `js:"duplicateStyles"`
+	GlobalSelectors []GlobalSelector `js:"globalSelectors"`
+	ScopeCollisions []ScopeCollision `js:"scopeCollisions"`
+}
+
+func makeStyleAnalysisResult(analysis transform.StyleAnalysis) StyleAnalysisResult {
+	result := StyleAnalysisResult{
+		DuplicateStyles: make([]DuplicateStyle, 0, len(analysis.DuplicateStyles)),
+		GlobalSelectors: make([]GlobalSelector, 0, len(analysis.GlobalSelectors)),
+		ScopeCollisions: make([]ScopeCollision, 0, len(analysis.ScopeCollisions)),
+	}
+	for _, duplicate := range analysis.DuplicateStyles {
+		locations := make([]StyleLocation, 0, len(duplicate.Locations))
+		for _, location := range duplicate.Locations {
+			locations = append(locations, StyleLocation{Filename: location.Filename, Start: location.Start})
+		}
+		result.DuplicateStyles = append(result.DuplicateStyles, DuplicateStyle{Hash: duplicate.Hash, Locations: locations})
+	}
+	for _, selector := range analysis.GlobalSelectors {
+		result.GlobalSelectors = append(result.GlobalSelectors, GlobalSelector{Selector: selector.Selector, Components: selector.Components})
+	}
+	for _, collision := range analysis.ScopeCollisions {
+		result.ScopeCollisions = append(result.ScopeCollisions, ScopeCollision{Scope: collision.Scope, Components: collision.Components})
+	}
+	return result
+}
+
+func AnalyzeStyles() interface{} {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		sources := make([]transform.StyleSource, 0)
+		if input := args[0]; !input.IsUndefined() && !input.IsNull() {
+			for i := 0; i < input.Length(); i++ {
+				sources = append(sources, transform.StyleSource{
+					Filename: jsString(input.Index(i).Get("filename")),
+					Source:   jsString(input.Index(i).Get("source")),
+				})
+			}
+		}
+
+		handler := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			resolve := args[0]
+			reject := args[1]
+
+			analysis, err := transform.AnalyzeStyles(sources)
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+				return nil
+			}
+			resolve.Invoke(vert.ValueOf(makeStyleAnalysisResult(analysis)))
+			return nil
+		})
+		defer handler.Release()
+
+		promiseConstructor := js.Global().Get("Promise")
+		return promiseConstructor.New(handler)
+	})
+}
+
+func makeDiagnostics(docDiagnostics []loc.Diagnostic) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, len(docDiagnostics))
+	for _, diagnostic := range docDiagnostics {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: diagnostic.Severity.String(),
+			Text:     diagnostic.Text,
+			Start:    diagnostic.Range.Loc.Start,
+			End:      diagnostic.Range.End(),
+		})
+	}
+	return diagnostics
 }
 
 // This is spawned as a goroutine to preprocess style nodes using an async function passed from JS
@@ -129,7 +425,26 @@ func preprocessStyle(i int, style *astro.Node, transformOptions transform.Transf
 	if str == "" {
 		return
 	}
-	style.FirstChild.Data = str
+	transform.ApplyPreprocessed(style, str, parseSourceMap(data[0].Get("map")))
+}
+
+// Source maps are returned by preprocessors either as JSON or as an object.
+// One that can't be read is ignored, so mappings fall back to the <style> tag.
+func parseSourceMap(value js.Value) *sourcemap.SourceMap {
+	var source string
+	switch value.Type() {
+	case js.TypeString:
+		source = value.String()
+	case js.TypeObject:
+		source = js.Global().Get("JSON").Call("stringify", value).String()
+	default:
+		return nil
+	}
+	sm, err := sourcemap.ParseJSON([]byte(source))
+	if err != nil {
+		return nil
+	}
+	return sm
 }
 
 func Transform() interface{} {
@@ -141,6 +456,23 @@ func Transform() interface{} {
 		handler := js.FuncOf(func(this js.Value, args []js.Value) interface{} {
 			resolve := args[0]
 
+			// Only the metadata is needed, so skip preprocessing and code generation
+			if transformOptions.Mode == "analyze" {
+				result, err := transform.Analyze(source, transformOptions)
+				if err != nil {
+					fmt.Println(err)
+				}
+				resolve.Invoke(vert.ValueOf(TransformResult{
+					CSS:          []string{},
+					StyleImports: makeStyleImports(result.StyleImports),
+					Imports:      makeImports(result.Analysis),
+					Analysis:     makeComponentAnalysis(result.Analysis),
+					Scripts:      []HoistedScript{},
+					Diagnostics:  makeDiagnostics(result.Diagnostics),
+				}))
+				return nil
+			}
+
 			var doc *astro.Node
 
 			if transformOptions.As == "document" {
@@ -188,6 +520,8 @@ func Transform() interface{} {
 
 			// Perform CSS and element scoping as needed
 			transform.Transform(doc, transformOptions)
+			// Analyze before printing, since the printer adds attributes of its own
+			analysis := transform.AnalyzeComponent(doc)
 
 			css := []string{}
 			// Only perform static CSS extraction if the flag is passed in.
@@ -199,24 +533,30 @@ func Transform() interface{} {
 			}
 
 			result := printer.PrintToJS(source, doc, len(css), transformOptions)
+			transformResult := TransformResult{
+				CSS:          css,
+				Code:         string(result.Output),
+				Map:          "",
+				StyleImports: makeStyleImports(doc.StyleImports),
+				Imports:      makeImports(analysis),
+				Analysis:     makeComponentAnalysis(analysis),
+				Scripts:      makeHoistedScripts(source, printer.PrintHoistedScripts(source, doc), transformOptions),
+				Diagnostics:  makeDiagnostics(doc.Diagnostics),
+			}
 
 			switch transformOptions.SourceMap {
 			case "external":
-				resolve.Invoke(createExternalSourceMap(source, result, css, transformOptions))
+				resolve.Invoke(createExternalSourceMap(source, result, transformResult, transformOptions))
 				return nil
 			case "both":
-				resolve.Invoke(createBothSourceMap(source, result, css, transformOptions))
+				resolve.Invoke(createBothSourceMap(source, result, transformResult, transformOptions))
 				return nil
 			case "inline":
-				resolve.Invoke(createInlineSourceMap(source, result, css, transformOptions))
+				resolve.Invoke(createInlineSourceMap(source, result, transformResult, transformOptions))
 				return nil
 			}
 
-			resolve.Invoke(vert.ValueOf(TransformResult{
-				CSS:  css,
-				Code: string(result.Output),
-				Map:  "",
-			}))
+			resolve.Invoke(vert.ValueOf(transformResult))
 
 			return nil
 		})
@@ -229,46 +569,32 @@ func Transform() interface{} {
 }
 
 func createSourceMapString(source string, result printer.PrintResult, transformOptions transform.TransformOptions) string {
-	sourcesContent, _ := json.Marshal(source)
-	sourcemap := RawSourceMap{
-		Version:        3,
-		Sources:        []string{transformOptions.Filename},
-		SourcesContent: []string{string(sourcesContent)},
-		Mappings:       string(result.SourceMapChunk.Buffer),
-	}
-	return fmt.Sprintf(`{
-  "version": 3,
-  "sources": ["%s"],
-  "sourcesContent": [%s],
-  "mappings": "%s",
-  "names": []
-}`, sourcemap.Sources[0], sourcemap.SourcesContent[0], sourcemap.Mappings)
-}
-
-func createExternalSourceMap(source string, result printer.PrintResult, css []string, transformOptions transform.TransformOptions) interface{} {
-	return vert.ValueOf(TransformResult{
-		CSS:  css,
-		Code: string(result.Output),
-		Map:  createSourceMapString(source, result, transformOptions),
-	})
+	sourcemapJSON := sourcemap.JSONSourceMap{
+		// Preprocessed styles can map into other files, like Sass partials
+		Sources:        append([]string{transformOptions.Filename}, result.SourceMapChunk.Sources...),
+		SourcesContent: append([]string{source}, result.SourceMapChunk.SourcesContent...),
+		Names:          result.SourceMapChunk.Names,
+		Mappings:       result.SourceMapChunk.Buffer,
+	}
+	return string(sourcemapJSON.Marshal())
+}
+
+func createExternalSourceMap(source string, result printer.PrintResult, transformResult TransformResult, transformOptions transform.TransformOptions) interface{} {
+	transformResult.Map = createSourceMapString(source, result, transformOptions)
+	return vert.ValueOf(transformResult)
 }
 
-func createInlineSourceMap(source string, result printer.PrintResult, css []string, transformOptions transform.TransformOptions) interface{} {
+func createInlineSourceMap(source string, result printer.PrintResult, transformResult TransformResult, transformOptions transform.TransformOptions) interface{} {
 	sourcemapString := createSourceMapString(source, result, transformOptions)
-	inlineSourcemap := `//# sourceMappingURL=data:application/json;charset=utf-8;base64,` + base64.StdEncoding.EncodeToString([]byte(sourcemapString))
-	return vert.ValueOf(TransformResult{
-		CSS:  css,
-		Code: string(result.Output) + "\n" + inlineSourcemap,
-		Map:  "",
-	})
+	inlineSourcemap := sourcemap.InlineComment([]byte(sourcemapString))
+	transformResult.Code = string(result.Output) + "\n" + inlineSourcemap
+	return vert.ValueOf(transformResult)
 }
 
-func createBothSourceMap(source string, result printer.PrintResult, css []string, transformOptions transform.TransformOptions) interface{} {
+func createBothSourceMap(source string, result printer.PrintResult, transformResult TransformResult, transformOptions transform.TransformOptions) interface{} {
 	sourcemapString := createSourceMapString(source, result, transformOptions)
-	inlineSourcemap := `//# sourceMappingURL=data:application/json;charset=utf-8;base64,` + base64.StdEncoding.EncodeToString([]byte(sourcemapString))
-	return vert.ValueOf(TransformResult{
-		CSS:  css,
-		Code: string(result.Output) + "\n" + inlineSourcemap,
-		Map:  sourcemapString,
-	})
+	inlineSourcemap := sourcemap.InlineComment([]byte(sourcemapString))
+	transformResult.Code = string(result.Output) + "\n" + inlineSourcemap
+	transformResult.Map = sourcemapString
+	return vert.ValueOf(transformResult)
 }
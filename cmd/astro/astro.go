@@ -1,17 +1,27 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
 	astro "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/printer"
+	"github.com/withastro/compiler/internal/sourcemap"
 	"github.com/withastro/compiler/internal/transform"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "analyze-styles" {
+		analyzeStyles(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "sourcemap" {
+		sourcemapCommand(os.Args[2:])
+		return
+	}
+
 	source := `
 ---
 import Component from '../components/Component.vue';
@@ -55,13 +65,47 @@ const something = await Astro.fetchContent('../*.md');
 
 	result := printer.PrintToJS(source, doc, 0, transform.TransformOptions{})
 
-	content, _ := json.Marshal(source)
-	sourcemap := `{ "version": 3, "sources": ["file.astro"], "names": [], "mappings": "` + string(result.SourceMapChunk.Buffer) + `", "sourcesContent": [` + string(content) + `] }`
-	b64 := base64.StdEncoding.EncodeToString([]byte(sourcemap))
-	output := string(result.Output) + string('\n') + `//# sourceMappingURL=data:application/json;base64,` + b64 + string('\n')
+	sourcemapJSON := sourcemap.JSONSourceMap{
+		Sources:        append([]string{"file.astro"}, result.SourceMapChunk.Sources...),
+		SourcesContent: append([]string{source}, result.SourceMapChunk.SourcesContent...),
+		Names:          result.SourceMapChunk.Names,
+		Mappings:       result.SourceMapChunk.Buffer,
+	}
+	output := string(result.Output) + string('\n') + sourcemap.InlineComment(sourcemapJSON.Marshal()) + string('\n')
 	fmt.Print(output)
 }
 
+// Usage: astro analyze-styles <files...>
+//
+// Prints the analysis as JSON. Errors go to stderr and make the command fail.
+func analyzeStyles(filenames []string) {
+	if len(filenames) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: astro analyze-styles <files...>")
+		os.Exit(1)
+	}
+	sources := make([]transform.StyleSource, 0, len(filenames))
+	for _, filename := range filenames {
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sources = append(sources, transform.StyleSource{Filename: filename, Source: string(source)})
+	}
+
+	analysis, err := transform.AnalyzeStyles(sources)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	output, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(output))
+}
+
 // 	// z := astro.NewTokenizer(strings.NewReader(source))
 
 // 	// for {
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/printer"
+	"github.com/withastro/compiler/internal/sourcemap"
+	"github.com/withastro/compiler/internal/transform"
+)
+
+// How many colors the segments of a line cycle through
+const segmentColors = 6
+
+// Original lines wider than this are cut off in the terminal
+const maxColumnWidth = 72
+
+// A highlighted part of a line, in bytes
+type segment struct {
+	start, end int
+	color      int
+	title      string
+}
+
+// Usage: astro sourcemap [--html] <file>
+//
+// Compiles a component and shows its source next to the generated code, with
+// every mapping highlighted in the same color on both sides. Problems with
+// the mappings are listed afterwards, and make the command fail.
+func sourcemapCommand(args []string) {
+	flags := flag.NewFlagSet("sourcemap", flag.ExitOnError)
+	asHTML := flags.Bool("html", false, "print an HTML page instead of terminal output")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: astro sourcemap [--html] <file>")
+		os.Exit(1)
+	}
+	filename := flags.Arg(0)
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	result, err := compile(filename, string(source))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	chunk := result.SourceMapChunk
+	mappings, err := sourcemap.DecodeMappings(string(chunk.Buffer), 1+len(chunk.Sources), len(chunk.Names))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	problems, err := sourcemap.Validate(string(chunk.Buffer), string(result.Output), append([]string{string(source)}, chunk.SourcesContent...), len(chunk.Names))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	originalLines := strings.Split(string(source), "\n")
+	generatedLines := strings.Split(string(result.Output), "\n")
+	original, generated := highlightSegments(mappings, chunk.Names, originalLines, generatedLines)
+	if *asHTML {
+		fmt.Print(renderHTML(filename, originalLines, generatedLines, original, generated, problems))
+	} else {
+		fmt.Print(renderText(originalLines, generatedLines, original, generated, problems))
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+// Compiles a component with the default options, apart from its filename
+func compile(filename string, source string) (printer.PrintResult, error) {
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		return printer.PrintResult{}, err
+	}
+	opts := transform.TransformOptions{Scope: astro.HashFromSource(source), Filename: filename}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, opts)
+	return printer.PrintToJS(source, doc, 0, opts), nil
+}
+
+// Returns the segments of each original and generated line. A mapping covers
+// the generated code up to the next mapping on its line, and the original
+// code up to the next location that any mapping points at.
+func highlightSegments(mappings []sourcemap.Mapping, names []string, originalLines []string, generatedLines []string) ([][]segment, [][]segment) {
+	original := make([][]segment, len(originalLines))
+	generated := make([][]segment, len(generatedLines))
+
+	for i, m := range mappings {
+		if m.GeneratedLine >= len(generatedLines) {
+			continue
+		}
+		line := generatedLines[m.GeneratedLine]
+		start, _ := sourcemap.ByteOffset(line, 0, m.GeneratedColumn)
+		end := len(line)
+		if i+1 < len(mappings) && mappings[i+1].GeneratedLine == m.GeneratedLine {
+			end, _ = sourcemap.ByteOffset(line, 0, mappings[i+1].GeneratedColumn)
+		}
+		title := fmt.Sprintf("%d:%d → %d:%d", m.GeneratedLine+1, m.GeneratedColumn, m.OriginalLine+1, m.OriginalColumn)
+		if m.NameIndex != -1 {
+			title += " " + names[m.NameIndex]
+		}
+		if end > start {
+			generated[m.GeneratedLine] = append(generated[m.GeneratedLine], segment{start: start, end: end, color: i % segmentColors, title: title})
+		}
+	}
+
+	// The first mapping to point at a location picks its color
+	byOriginal := make([]int, len(mappings))
+	for i := range byOriginal {
+		byOriginal[i] = i
+	}
+	sort.SliceStable(byOriginal, func(i, j int) bool {
+		a, b := mappings[byOriginal[i]], mappings[byOriginal[j]]
+		return a.OriginalLine < b.OriginalLine || (a.OriginalLine == b.OriginalLine && a.OriginalColumn < b.OriginalColumn)
+	})
+	for i, index := range byOriginal {
+		m := mappings[index]
+		// Only the component itself is shown, not other sources it maps into
+		if m.SourceIndex != 0 || m.OriginalLine >= len(originalLines) {
+			continue
+		}
+		if i > 0 {
+			prev := mappings[byOriginal[i-1]]
+			if prev.OriginalLine == m.OriginalLine && prev.OriginalColumn == m.OriginalColumn {
+				continue
+			}
+		}
+		line := originalLines[m.OriginalLine]
+		start, _ := sourcemap.ByteOffset(line, 0, m.OriginalColumn)
+		end := len(line)
+		for _, nextIndex := range byOriginal[i+1:] {
+			next := mappings[nextIndex]
+			if next.OriginalLine != m.OriginalLine {
+				break
+			}
+			if next.OriginalColumn != m.OriginalColumn {
+				end, _ = sourcemap.ByteOffset(line, 0, next.OriginalColumn)
+				break
+			}
+		}
+		if end > start {
+			original[m.OriginalLine] = append(original[m.OriginalLine], segment{start: start, end: end, color: index % segmentColors})
+		}
+	}
+	return original, generated
+}
+
+func renderText(originalLines []string, generatedLines []string, original [][]segment, generated [][]segment, problems []sourcemap.Problem) string {
+	width := 0
+	for _, line := range originalLines {
+		if w := utf8.RuneCountInString(expandTabs(line)); w > width {
+			width = w
+		}
+	}
+	if width > maxColumnWidth {
+		width = maxColumnWidth
+	}
+
+	var b strings.Builder
+	rows := len(originalLines)
+	if len(generatedLines) > rows {
+		rows = len(generatedLines)
+	}
+	for i := 0; i < rows; i++ {
+		if i < len(originalLines) {
+			fmt.Fprintf(&b, "%4d │ %s", i+1, renderTextLine(originalLines[i], original[i], width))
+		} else {
+			fmt.Fprintf(&b, "     │ %s", strings.Repeat(" ", width))
+		}
+		if i < len(generatedLines) {
+			fmt.Fprintf(&b, " │ %4d │ %s", i+1, renderTextLine(generatedLines[i], generated[i], -1))
+		}
+		b.WriteString("\n")
+	}
+
+	for _, problem := range problems {
+		fmt.Fprintf(&b, "\n%d:%d %s", problem.GeneratedLine+1, problem.GeneratedColumn, problem.Text)
+	}
+	if len(problems) > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// Renders a line with ANSI colors for its segments, padded or cut off to
+// width. A negative width leaves the line as long as it is.
+func renderTextLine(line string, segments []segment, width int) string {
+	var b strings.Builder
+	visible := 0
+	color := -1
+	for i, c := range line {
+		next := -1
+		for _, s := range segments {
+			if i >= s.start && i < s.end {
+				next = s.color
+				break
+			}
+		}
+		if next != color {
+			if next == -1 {
+				b.WriteString("\x1b[0m")
+			} else {
+				fmt.Fprintf(&b, "\x1b[30;%dm", 41+next)
+			}
+			color = next
+		}
+		text := string(c)
+		if c == '\t' {
+			text = "  "
+		}
+		if width >= 0 && visible+utf8.RuneCountInString(text) > width {
+			break
+		}
+		b.WriteString(text)
+		visible += utf8.RuneCountInString(text)
+	}
+	if color != -1 {
+		b.WriteString("\x1b[0m")
+	}
+	if width > visible {
+		b.WriteString(strings.Repeat(" ", width-visible))
+	}
+	return b.String()
+}
+
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", "  ")
+}
+
+func renderHTML(filename string, originalLines []string, generatedLines []string, original [][]segment, generated [][]segment, problems []sourcemap.Problem) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(filename))
+	b.WriteString("<style>\nbody { display: flex; gap: 2em; font-family: monospace; }\npre { margin: 0; }\n")
+	for i, color := range []string{"#fbb", "#bfb", "#bbf", "#ffb", "#bff", "#fbf"}[:segmentColors] {
+		fmt.Fprintf(&b, ".m%d { background: %s; }\n", i, color)
+	}
+	b.WriteString(".problems { color: #c00; }\n</style>\n</head>\n<body>\n")
+
+	b.WriteString("<pre>")
+	for i, line := range originalLines {
+		b.WriteString(renderHTMLLine(line, original[i]))
+		b.WriteString("\n")
+	}
+	b.WriteString("</pre>\n<pre>")
+	for i, line := range generatedLines {
+		b.WriteString(renderHTMLLine(line, generated[i]))
+		b.WriteString("\n")
+	}
+	b.WriteString("</pre>\n")
+
+	if len(problems) > 0 {
+		b.WriteString("<ul class=\"problems\">\n")
+		for _, problem := range problems {
+			fmt.Fprintf(&b, "<li>%d:%d %s</li>\n", problem.GeneratedLine+1, problem.GeneratedColumn, html.EscapeString(problem.Text))
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+func renderHTMLLine(line string, segments []segment) string {
+	var b strings.Builder
+	printed := 0
+	for _, s := range segments {
+		if s.start < printed {
+			continue
+		}
+		b.WriteString(html.EscapeString(line[printed:s.start]))
+		fmt.Fprintf(&b, "<span class=\"m%d\" title=\"%s\">%s</span>", s.color, html.EscapeString(s.title), html.EscapeString(line[s.start:s.end]))
+		printed = s.end
+	}
+	b.WriteString(html.EscapeString(line[printed:]))
+	return b.String()
+}
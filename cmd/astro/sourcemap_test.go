@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/withastro/compiler/internal/sourcemap"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestCompiledSourceMapIsValid(t *testing.T) {
+	source := `---
+import Card from './Card.astro';
+export const prerender = true;
+const { title } = Astro.props;
+---
+<html>
+<body>
+  <Card title={title} client:load />
+  <style>p { color: red }</style>
+  <script>console.log(1)</script>
+</body>
+</html>`
+	result, err := compile("src/pages/index.astro", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := result.SourceMapChunk
+	problems, err := sourcemap.Validate(string(chunk.Buffer), string(result.Output), []string{source}, len(chunk.Names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Errorf("expected no problems, got %v\n%s", problems, result.Output)
+	}
+}
+
+func TestHighlightSegments(t *testing.T) {
+	mappings := []sourcemap.Mapping{
+		{GeneratedLine: 0, GeneratedColumn: 3, OriginalLine: 0, OriginalColumn: 0, NameIndex: -1},
+		{GeneratedLine: 0, GeneratedColumn: 9, OriginalLine: 0, OriginalColumn: 6, NameIndex: 0},
+	}
+	original, generated := highlightSegments(mappings, []string{"a"}, []string{"const a = 1"}, []string{"x; const a = 1", ""})
+
+	wantOriginal := [][]segment{{{start: 0, end: 6, color: 0}, {start: 6, end: 11, color: 1}}}
+	wantGenerated := [][]segment{
+		{{start: 3, end: 9, color: 0, title: "1:3 → 1:0"}, {start: 9, end: 14, color: 1, title: "1:9 → 1:6 a"}},
+		nil,
+	}
+	if diff := test_utils.ANSIDiff(fmt.Sprint(wantOriginal), fmt.Sprint(original)); diff != "" {
+		t.Errorf("original mismatch (-want +got):\n%s", diff)
+	}
+	if diff := test_utils.ANSIDiff(fmt.Sprint(wantGenerated), fmt.Sprint(generated)); diff != "" {
+		t.Errorf("generated mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	original := [][]segment{{{start: 0, end: 1, color: 0}}}
+	generated := [][]segment{{{start: 1, end: 2, color: 1}}, nil}
+	problems := []sourcemap.Problem{{GeneratedLine: 1, Text: "has no mappings"}}
+	got := renderText([]string{"ab"}, []string{"ab", "c"}, original, generated, problems)
+
+	want := "   1 │ \x1b[30;41ma\x1b[0mb │    1 │ a\x1b[30;42mb\x1b[0m\n" +
+		"     │    │    2 │ c\n" +
+		"\n2:0 has no mappings\n"
+	if diff := test_utils.ANSIDiff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	original := [][]segment{{{start: 0, end: 3, color: 0}}}
+	generated := [][]segment{{{start: 2, end: 5, color: 0, title: "1:2 → 1:0"}}}
+	problems := []sourcemap.Problem{{GeneratedLine: 0, GeneratedColumn: 2, Text: "points at <nothing>"}}
+	got := renderHTML("a<b>.astro", []string{"<a>"}, []string{"$$<a>"}, original, generated, problems)
+
+	for _, want := range []string{
+		"<title>a&lt;b&gt;.astro</title>",
+		`<pre><span class="m0" title="">&lt;a&gt;</span>` + "\n</pre>",
+		`<pre>$$<span class="m0" title="1:2 → 1:0">&lt;a&gt;</span>` + "\n</pre>",
+		"<li>1:2 points at &lt;nothing&gt;</li>",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package js_scanner
+
+import (
+	"sort"
+
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// An identifier and the offset where it appears in the source
+type Reference struct {
+	Name  string
+	Start int
+}
+
+// Properties of `Astro` which only exist inside of the render function.
+// The top-level `Astro` global doesn't know anything about the current request.
+var renderScopeAstroProperties = map[string]bool{
+	"props":   true,
+	"request": true,
+	"slots":   true,
+}
+
+// The bindings of a single top-level statement of the frontmatter
+type StatementBindings struct {
+	Statement
+	// Names the statement declares at the top level of the frontmatter,
+	// including imported names and types
+	Declarations []Reference
+	// Identifiers the statement uses but doesn't declare itself, which
+	// either come from other statements or are globals
+	References []Reference
+}
+
+// FindBindings builds a table of what each top-level statement of the
+// frontmatter declares and references. Nested scopes are not tracked, so
+// a name declared anywhere inside of a statement counts as a local for the
+// whole statement.
+func FindBindings(source []byte) []StatementBindings {
+	statements, statementTokens, _ := parseStatements(source)
+	bindings := make([]StatementBindings, 0, len(statements))
+	for i, statement := range statements {
+		tokens := statementTokens[i]
+		declarations := declaredNames(tokens)
+		if declarations == nil {
+			declarations = make([]Reference, 0)
+		}
+		references := make([]Reference, 0)
+		for _, j := range freeReferences(tokens) {
+			references = append(references, Reference{Name: string(tokens[j].value), Start: tokens[j].start})
+		}
+		bindings = append(bindings, StatementBindings{
+			Statement:    statement,
+			Declarations: declarations,
+			References:   references,
+		})
+	}
+	return bindings
+}
+
+// FindNames returns every name that the source declares, at any depth, or
+// references without declaring it. Property names and object keys are not
+// names, so they're left out.
+func FindNames(source []byte) []Reference {
+	statements, statementTokens, _ := parseStatements(source)
+	names := make([]Reference, 0)
+	seen := make(map[int]bool)
+	add := func(ref Reference) {
+		if !seen[ref.Start] {
+			seen[ref.Start] = true
+			names = append(names, ref)
+		}
+	}
+	for i := range statements {
+		tokens := statementTokens[i]
+		for _, ref := range declaredNames(tokens) {
+			add(ref)
+		}
+		for _, ref := range localNames(tokens) {
+			add(ref)
+		}
+		for _, j := range freeReferences(tokens) {
+			add(Reference{Name: string(tokens[j].value), Start: tokens[j].start})
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Start < names[j].Start })
+	return names
+}
+
+// FindRenderScopeReferences returns the identifiers used by exported statements
+// which only exist inside of the render function: render body declarations and
+// request-specific properties of `Astro`. Exports are hoisted to the top level of
+// the module, so these references would throw at runtime.
+func FindRenderScopeReferences(source []byte) []Reference {
+	bindings := FindBindings(source)
+	renderScope := make(map[string]bool)
+	for _, statement := range bindings {
+		if statement.Kind != StatementBody {
+			continue
+		}
+		for _, name := range statement.Declarations {
+			renderScope[name.Name] = true
+		}
+	}
+
+	references := make([]Reference, 0)
+	for _, statement := range bindings {
+		if statement.Kind != StatementExport {
+			continue
+		}
+		for _, ref := range statement.References {
+			if renderScope[ref.Name] {
+				references = append(references, ref)
+			} else if ref.Name == "Astro" {
+				if property := astroProperty(source[ref.Start:]); renderScopeAstroProperties[property] {
+					references = append(references, Reference{Name: ref.Name + "." + property, Start: ref.Start})
+				}
+			}
+		}
+	}
+	return references
+}
+
+// FindExports returns the names the frontmatter exports and where each of them
+// appears. Type-only exports are erased, so they're left out, and so is
+// `export * from "a"`, since the names it exports aren't known here.
+func FindExports(source []byte) []Reference {
+	statements, statementTokens, _ := parseStatements(source)
+	exports := make([]Reference, 0)
+	for i, statement := range statements {
+		tokens := statementTokens[i]
+		if statement.Kind != StatementExport || len(tokens) < 2 {
+			continue
+		}
+		switch next := tokens[1]; {
+		case next.tt == js.DefaultToken:
+			exports = append(exports, Reference{Name: "default", Start: next.start})
+		case next.tt == js.MulToken:
+			// `export * as a from "b"`
+			if len(tokens) > 3 && tokens[2].tt == js.AsToken {
+				exports = append(exports, exportName(tokens[3]))
+			}
+		case next.tt == js.OpenBraceToken:
+			exports = append(exports, exportSpecifiers(tokens[2:matchingBracket(tokens, 1)])...)
+		case next.is("type") || next.tt == js.InterfaceToken || next.is("declare"):
+			continue
+		default:
+			exports = append(exports, declaredNames(tokens)...)
+		}
+	}
+	return exports
+}
+
+// Whether an export statement exports `default`, including `export { a as default }`
+func exportsDefault(tokens []token) bool {
+	if len(tokens) < 2 {
+		return false
+	}
+	switch next := tokens[1]; {
+	case next.tt == js.DefaultToken:
+		return true
+	case next.tt == js.MulToken:
+		return len(tokens) > 3 && tokens[2].tt == js.AsToken && exportName(tokens[3]).Name == "default"
+	case next.tt == js.OpenBraceToken:
+		for _, ref := range exportSpecifiers(tokens[2:matchingBracket(tokens, 1)]) {
+			if ref.Name == "default" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the exported names of the specifiers inside of `export { a, b as c, type d }`
+func exportSpecifiers(tokens []token) []Reference {
+	names := make([]Reference, 0)
+	for len(tokens) > 0 {
+		end := 0
+		for end < len(tokens) && tokens[end].tt != js.CommaToken {
+			end++
+		}
+		specifier := tokens[:end]
+		if end < len(tokens) {
+			end++
+		}
+		tokens = tokens[end:]
+
+		if len(specifier) == 0 {
+			continue
+		}
+		// `type d`, but not `type` or `type as e`
+		if specifier[0].is("type") && len(specifier) > 1 && specifier[1].tt != js.AsToken {
+			continue
+		}
+		name := specifier[0]
+		if len(specifier) > 2 && specifier[len(specifier)-2].tt == js.AsToken {
+			name = specifier[len(specifier)-1]
+		}
+		names = append(names, exportName(name))
+	}
+	return names
+}
+
+// Export names are identifiers or, since ES2022, string literals
+func exportName(t token) Reference {
+	if t.tt == js.StringToken {
+		return Reference{Name: string(t.value[1 : len(t.value)-1]), Start: t.start + 1}
+	}
+	return Reference{Name: string(t.value), Start: t.start}
+}
+
+// Returns `b` when the source starts with `a.b`
+func astroProperty(source []byte) string {
+	// Only the first few tokens are needed
+	end := len(source)
+	if end > 64 {
+		end = 64
+	}
+	tokens, _, _ := tokenize(source[:end:end])
+	if len(tokens) >= 3 && tokens[1].tt == js.DotToken && js.IsIdentifierName(tokens[2].tt) {
+		return string(tokens[2].value)
+	}
+	return ""
+}
+
+// Returns the names that a top-level statement declares in its scope
+func declaredNames(tokens []token) []Reference {
+	i := 0
+	for i < len(tokens) {
+		t := tokens[i]
+		if t.tt == atToken {
+			i = skipDecorator(tokens, i)
+			continue
+		}
+		if (t.tt == js.ExportToken && i+1 < len(tokens) && tokens[i+1].tt != js.OpenBraceToken && tokens[i+1].tt != js.MulToken) || t.tt == js.DefaultToken || t.tt == js.AsyncToken || t.is("declare") || t.is("abstract") {
+			i++
+			continue
+		}
+		break
+	}
+	if i >= len(tokens) {
+		return nil
+	}
+
+	switch t := tokens[i]; {
+	case t.tt == js.ImportToken:
+		return importNames(tokens)
+	case t.tt == js.ExportToken:
+		// `export { a }` and `export * from "b"` don't declare anything
+		return nil
+	case t.is("type") || t.tt == js.InterfaceToken || t.is("namespace") || t.is("module"):
+		return identifierAt(tokens, i+1)
+	}
+
+	switch tokens[i].tt {
+	case js.VarToken, js.LetToken, js.ConstToken:
+		if i+2 < len(tokens) && tokens[i+1].tt == js.EnumToken {
+			return identifierAt(tokens, i+2)
+		}
+		return patternNames(tokens[i+1 : declarationEnd(tokens, i+1)])
+	case js.FunctionToken:
+		if i+1 < len(tokens) && tokens[i+1].tt == js.MulToken {
+			i++
+		}
+		return identifierAt(tokens, i+1)
+	case js.ClassToken, js.EnumToken:
+		return identifierAt(tokens, i+1)
+	}
+	return nil
+}
+
+// Returns the local names of an import statement, like `a`, `c` and `d` in
+// `import a, { b as c, type d } from "e"`
+func importNames(tokens []token) []Reference {
+	names := make([]Reference, 0)
+	for i := 1; i < len(tokens); i++ {
+		t := tokens[i]
+		if t.tt == js.FromToken || t.tt == js.StringToken || t.tt == js.EqToken {
+			break
+		}
+		if !js.IsIdentifier(t.tt) || t.tt == js.AsToken {
+			continue
+		}
+		next := token{}
+		if i+1 < len(tokens) {
+			next = tokens[i+1]
+		}
+		// `b as c` imports `b` under the name `c`
+		if next.tt == js.AsToken {
+			continue
+		}
+		// `type` is a modifier in `import type a` and `import { type a }`
+		if t.is("type") && (js.IsIdentifier(next.tt) && next.tt != js.FromToken || next.tt == js.OpenBraceToken || next.tt == js.MulToken) {
+			continue
+		}
+		names = append(names, Reference{Name: string(t.value), Start: t.start})
+	}
+	return names
+}
+
+// Returns every name declared anywhere inside of a statement, including
+// nested declarations and function parameters. Scopes are not tracked,
+// so a name declared in one function hides it everywhere in the statement.
+func localNames(tokens []token) []Reference {
+	names := make([]Reference, 0)
+	for i, t := range tokens {
+		switch t.tt {
+		case js.VarToken, js.LetToken, js.ConstToken:
+			names = append(names, patternNames(tokens[i+1:declarationEnd(tokens, i+1)])...)
+		case js.FunctionToken, js.ClassToken:
+			j := i + 1
+			if j < len(tokens) && tokens[j].tt == js.MulToken {
+				j++
+			}
+			names = append(names, identifierAt(tokens, j)...)
+		case js.OpenParenToken:
+			// Parameters are followed by a function body, an arrow or a return type
+			end := matchingBracket(tokens, i)
+			if end+1 < len(tokens) {
+				switch tokens[end+1].tt {
+				case js.ArrowToken, js.OpenBraceToken, js.ColonToken:
+					names = append(names, patternNames(tokens[i+1:end])...)
+				}
+			}
+		default:
+			if js.IsIdentifier(t.tt) && i+1 < len(tokens) && tokens[i+1].tt == js.ArrowToken {
+				names = append(names, Reference{Name: string(t.value), Start: t.start})
+			}
+		}
+	}
+	return names
+}
+
+// Words which are keywords when they are followed by an identifier, but can be names otherwise
+var modifierWords = map[string]bool{
+	"type":      true,
+	"declare":   true,
+	"namespace": true,
+	"module":    true,
+	"abstract":  true,
+	"readonly":  true,
+	"keyof":     true,
+	"infer":     true,
+	"is":        true,
+	"asserts":   true,
+	"unique":    true,
+	"satisfies": true,
+	"override":  true,
+}
+
+// Returns the indexes of the identifiers a statement uses without declaring them
+func freeReferences(tokens []token) []int {
+	references := make([]int, 0)
+	if len(tokens) == 0 || tokens[0].tt == js.ImportToken && classifyStatement(tokens, 0) == StatementImport || isReexport(tokens) {
+		return references
+	}
+
+	locals := make(map[string]bool)
+	for _, name := range declaredNames(tokens) {
+		locals[name.Name] = true
+	}
+	for _, name := range localNames(tokens) {
+		locals[name.Name] = true
+	}
+
+	brackets := make([]js.TokenType, 0)
+	isClass := false
+	for i, t := range tokens {
+		switch t.tt {
+		case js.ClassToken:
+			isClass = true
+		case js.OpenBraceToken, js.OpenBracketToken, js.OpenParenToken, js.TemplateStartToken:
+			// Class bodies and object literals get markers of their own, so that member names can be skipped
+			if isClass && t.tt == js.OpenBraceToken {
+				brackets = append(brackets, js.ClassToken)
+				isClass = false
+				continue
+			}
+			if t.tt == js.OpenBraceToken && isObjectLiteral(tokens, i) {
+				brackets = append(brackets, objectToken)
+				continue
+			}
+			brackets = append(brackets, t.tt)
+			continue
+		case js.CloseBraceToken, js.CloseBracketToken, js.CloseParenToken, js.TemplateEndToken:
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+			continue
+		}
+
+		if t.tt != js.IdentifierToken || locals[string(t.value)] {
+			continue
+		}
+		// TypeScript keywords, like `type` in `type A = B`
+		if modifierWords[string(t.value)] && i+1 < len(tokens) && !tokens[i+1].newline && js.IsIdentifierName(tokens[i+1].tt) {
+			continue
+		}
+		// Property access, like `a.b`, or the exported name in `export { a as b }`
+		if i > 0 && (tokens[i-1].tt == js.DotToken || tokens[i-1].tt == js.OptChainToken || tokens[i-1].tt == js.AsToken) {
+			continue
+		}
+		// Class members, like `a = 1` or `b() {}`
+		if len(brackets) > 0 && brackets[len(brackets)-1] == js.ClassToken && isMemberName(tokens, i) {
+			continue
+		}
+		// Object keys, like `{ b: 1 }`
+		if i+1 < len(tokens) && tokens[i+1].tt == js.ColonToken && len(brackets) > 0 && (brackets[len(brackets)-1] == js.OpenBraceToken || brackets[len(brackets)-1] == objectToken) {
+			continue
+		}
+		// Object methods, like `{ b() {} }` or `{ get c() {} }`
+		if len(brackets) > 0 && brackets[len(brackets)-1] == objectToken && isMethodName(tokens, i) {
+			continue
+		}
+		references = append(references, i)
+	}
+	return references
+}
+
+// The marker of an object literal on the bracket stack of freeReferences
+const objectToken js.TokenType = 0xfffe
+
+// Does the `{` at i start an object literal, rather than a block?
+// Only the token before it is checked, which is enough for the places
+// an object literal can appear in an expression.
+func isObjectLiteral(tokens []token, i int) bool {
+	if i == 0 {
+		return false
+	}
+	switch prev := tokens[i-1]; prev.tt {
+	case js.ArrowToken:
+		return false
+	case js.OpenParenToken, js.OpenBracketToken, js.CommaToken, js.ColonToken, js.QuestionToken, js.EllipsisToken,
+		js.ReturnToken, js.TemplateStartToken, js.TemplateMiddleToken:
+		return true
+	default:
+		return js.IsOperator(prev.tt)
+	}
+}
+
+// Is the identifier at i, directly inside of an object literal, the name of a method?
+func isMethodName(tokens []token, i int) bool {
+	if i+1 >= len(tokens) || tokens[i+1].tt != js.OpenParenToken && tokens[i+1].tt != js.LtToken {
+		return false
+	}
+	switch tokens[i-1].tt {
+	case objectToken, js.OpenBraceToken, js.CommaToken, js.GetToken, js.SetToken, js.AsyncToken, js.MulToken:
+		return true
+	}
+	return false
+}
+
+// Is the identifier at i, directly inside of a class body, the name of a member?
+func isMemberName(tokens []token, i int) bool {
+	prev := tokens[i-1]
+	if prev.tt == js.OpenBraceToken || prev.tt == js.SemicolonToken || prev.tt == js.CloseBraceToken || prev.tt == atToken || tokens[i].newline {
+		return true
+	}
+	switch prev.tt {
+	case js.StaticToken, js.GetToken, js.SetToken, js.AsyncToken, js.PublicToken, js.PrivateToken, js.ProtectedToken, js.MulToken:
+		return true
+	}
+	return prev.is("readonly") || prev.is("declare") || prev.is("abstract") || prev.is("override") || prev.is("accessor")
+}
+
+// Is this `export ... from "a"`?
+func isReexport(tokens []token) bool {
+	if tokens[0].tt != js.ExportToken {
+		return false
+	}
+	for i := 1; i+1 < len(tokens); i++ {
+		if tokens[i].tt == js.FromToken && tokens[i+1].tt == js.StringToken {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the names bound by a binding pattern, like the declarators of
+// `const a = 1, { b, c: [d] } = e` or the parameters of `(f: T, g = 1) => {}`
+func patternNames(tokens []token) []Reference {
+	names := make([]Reference, 0)
+	brackets := make([]js.TokenType, 0)
+	// While skipping a type annotation or an initializer, the depth where it started
+	skipDepth := -1
+	angles := 0
+
+	for i, t := range tokens {
+		switch t.tt {
+		case js.OpenBraceToken, js.OpenBracketToken, js.OpenParenToken, js.TemplateStartToken:
+			brackets = append(brackets, t.tt)
+			continue
+		case js.CloseBraceToken, js.CloseBracketToken, js.CloseParenToken, js.TemplateEndToken:
+			if len(brackets) > 0 {
+				brackets = brackets[:len(brackets)-1]
+			}
+			if skipDepth > len(brackets) {
+				skipDepth = -1
+			}
+			continue
+		}
+		depth := len(brackets)
+
+		if skipDepth != -1 {
+			switch t.tt {
+			case js.LtToken:
+				angles++
+			case js.GtToken, js.GtGtToken, js.GtGtGtToken:
+				angles -= len(t.value)
+			case js.CommaToken:
+				if depth == skipDepth && angles <= 0 {
+					skipDepth = -1
+					angles = 0
+				}
+			}
+			continue
+		}
+
+		switch {
+		case t.tt == js.EqToken:
+			skipDepth = depth
+		case t.tt == js.ColonToken:
+			// In `{ a: b }` the name comes after the colon, anywhere else it's a type
+			if depth == 0 || brackets[depth-1] != js.OpenBraceToken {
+				skipDepth = depth
+			}
+		case js.IsIdentifier(t.tt):
+			if depth > 0 && brackets[depth-1] == js.OpenBraceToken && i+1 < len(tokens) && tokens[i+1].tt == js.ColonToken {
+				continue
+			}
+			names = append(names, Reference{Name: string(t.value), Start: t.start})
+		}
+	}
+	return names
+}
+
+// Returns the index right after the declarators which start at i
+func declarationEnd(tokens []token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].tt {
+		case js.OpenBraceToken, js.OpenBracketToken, js.OpenParenToken, js.TemplateStartToken:
+			depth++
+		case js.CloseBraceToken, js.CloseBracketToken, js.CloseParenToken, js.TemplateEndToken:
+			depth--
+			if depth < 0 {
+				return i
+			}
+		case js.SemicolonToken:
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+// Returns the index of the bracket which closes the one at i
+func matchingBracket(tokens []token, i int) int {
+	depth := 0
+	for ; i < len(tokens); i++ {
+		switch tokens[i].tt {
+		case js.OpenBraceToken, js.OpenBracketToken, js.OpenParenToken, js.TemplateStartToken:
+			depth++
+		case js.CloseBraceToken, js.CloseBracketToken, js.CloseParenToken, js.TemplateEndToken:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return i
+}
+
+func identifierAt(tokens []token, i int) []Reference {
+	if i < len(tokens) && js.IsIdentifier(tokens[i].tt) {
+		return []Reference{{Name: string(tokens[i].value), Start: tokens[i].start}}
+	}
+	return nil
+}
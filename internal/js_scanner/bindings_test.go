@@ -0,0 +1,256 @@
+package js_scanner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestFindBindings(t *testing.T) {
+	type bindings struct {
+		Declarations []string
+		References   []string
+	}
+	tests := []struct {
+		name   string
+		source string
+		want   []bindings
+	}{
+		{
+			name: "imports",
+			source: `import a, { b as c, type d } from "e";
+import * as f from "f";
+import type G from "g";
+import "h";`,
+			want: []bindings{
+				{Declarations: []string{"a", "c", "d"}, References: []string{}},
+				{Declarations: []string{"f"}, References: []string{}},
+				{Declarations: []string{"G"}, References: []string{}},
+				{Declarations: []string{}, References: []string{}},
+			},
+		},
+		{
+			name: "declarations",
+			source: `const a = b, { c, d: [e], ...f } = g;
+function h(i, j = k) { return i + j + l }
+class M extends N { o = p; q() { return this.o } }
+export const r: S<T, U> = v;
+type W = X;`,
+			want: []bindings{
+				{Declarations: []string{"a", "c", "e", "f"}, References: []string{"b", "g"}},
+				{Declarations: []string{"h"}, References: []string{"k", "l"}},
+				{Declarations: []string{"M"}, References: []string{"N", "p"}},
+				{Declarations: []string{"r"}, References: []string{"S", "T", "U", "v"}},
+				{Declarations: []string{"W"}, References: []string{"X"}},
+			},
+		},
+		{
+			name: "exports",
+			source: `export { a, b as c };
+export * from "d";
+export { e } from "f";
+export default g;`,
+			want: []bindings{
+				{Declarations: []string{}, References: []string{"a", "b"}},
+				{Declarations: []string{}, References: []string{}},
+				{Declarations: []string{}, References: []string{}},
+				{Declarations: []string{}, References: []string{"g"}},
+			},
+		},
+		{
+			name:   "object keys and properties",
+			source: "const a = { b: c, d, [e]: f.g, h() {} }, i = `${j?.k}`",
+			want: []bindings{
+				{Declarations: []string{"a", "i"}, References: []string{"c", "d", "e", "f", "j"}},
+			},
+		},
+		{
+			name: "object methods",
+			source: `const a = { async b() {}, get c() { return d }, set c(e) {}, *f() {} };
+function h() { i(); { j() } }`,
+			want: []bindings{
+				{Declarations: []string{"a"}, References: []string{"d"}},
+				{Declarations: []string{"h"}, References: []string{"i", "j"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]bindings, 0)
+			for _, statement := range FindBindings([]byte(tt.source)) {
+				b := bindings{Declarations: make([]string, 0), References: make([]string, 0)}
+				for _, ref := range statement.Declarations {
+					b.Declarations = append(b.Declarations, ref.Name)
+				}
+				for _, ref := range statement.References {
+					b.References = append(b.References, ref.Name)
+				}
+				got = append(got, b)
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestFindRenderScopeReferences(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name: "none",
+			source: `import { a } from "a";
+export const b = a + 1;
+const c = await fetch();`,
+			want: []string{},
+		},
+		{
+			name: "render body local",
+			source: `const data = await fetch();
+export const items = data.items;`,
+			want: []string{"data"},
+		},
+		{
+			name: "destructured local",
+			source: `const { title, tags: [first] } = Astro.props;
+export function getTitle() {
+  return title + first
+}`,
+			want: []string{"title", "first"},
+		},
+		{
+			name: "Astro",
+			source: `export const getStaticPaths = () => Astro.fetchContent('*.md');
+export const title = Astro.props.title;`,
+			want: []string{"Astro.props"},
+		},
+		{
+			name: "shadowed by parameters and locals",
+			source: `const data = await fetch();
+const item = data[0];
+export function format(data: Data, { item }) {
+  const other = data.item;
+  return [other, item].map((data) => data);
+}`,
+			want: []string{},
+		},
+		{
+			name: "properties and keys",
+			source: `const title = "";
+export const meta = { title: a.title };`,
+			want: []string{},
+		},
+		{
+			name: "object methods",
+			source: `const items = [1]
+export const o = { items() { return 1 } }`,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, ref := range FindRenderScopeReferences([]byte(tt.source)) {
+				got = append(got, ref.Name)
+				if !strings.HasPrefix(tt.source[ref.Start:], ref.Name) {
+					t.Errorf("wrong location %d for %s", ref.Start, ref.Name)
+				}
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestFindExports(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name: "declarations",
+			source: `export const a = 1, { b, c: [d] } = e;
+export let f;
+export async function getStaticPaths() {}
+export class G {}
+export enum H {}`,
+			want: []string{"a", "b", "d", "f", "getStaticPaths", "G", "H"},
+		},
+		{
+			name:   "default",
+			source: `export default function a() {}`,
+			want:   []string{"default"},
+		},
+		{
+			name: "specifiers",
+			source: `const a = 1, b = 2;
+export { a, b as c, type D, type as e, a as "f g" };
+export { h as default } from "h";`,
+			want: []string{"a", "c", "e", "f g", "default"},
+		},
+		{
+			name: "namespaces",
+			source: `export * as a from "a";
+export * from "b";`,
+			want: []string{"a"},
+		},
+		{
+			name: "types",
+			source: `export type A = string;
+export interface B {}
+export type { C } from "c";
+export declare const d: string;`,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, ref := range FindExports([]byte(tt.source)) {
+				got = append(got, ref.Name)
+				if !strings.HasPrefix(tt.source[ref.Start:], ref.Name) {
+					t.Errorf("wrong location %d for %s", ref.Start, ref.Name)
+				}
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestHoistExports(t *testing.T) {
+	source := `const a = 0;
+export const b = 1;
+const c = 2;
+export interface D {}
+export { c };
+export default function helper() {}`
+	got := HoistExports([]byte(source))
+	wantHoisted := []string{"", "\nexport const b = 1;", "\nexport interface D {}", "\nexport { c };"}
+	gotHoisted := make([]string, 0)
+	for _, hoisted := range got.Hoisted {
+		gotHoisted = append(gotHoisted, string(hoisted))
+	}
+	if diff := test_utils.ANSIDiff(wantHoisted, gotHoisted); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+	// The default export is the component, so this one isn't hoisted next to it
+	if diff := test_utils.ANSIDiff("const a = 0;\nconst c = 2;\nexport default function helper() {}", string(got.Body)); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+	body := ""
+	for _, span := range got.BodySpans {
+		body += source[span.Start:span.End]
+	}
+	if body != string(got.Body) {
+		t.Errorf("spans %v don't add up to the body, got %q", got.BodySpans, body)
+	}
+}
@@ -1,135 +1,29 @@
 package js_scanner
 
 import (
-	"io"
-
 	"github.com/tdewolff/parse/v2"
 	"github.com/tdewolff/parse/v2/js"
+	"github.com/withastro/compiler/internal/loc"
 )
 
 // This function returns the index at which we should split the frontmatter.
 // The first slice contains any top-level imports/exports, which are global.
 // The second slice contains any non-exported declarations, which are scoped to the render body
 //
-// Why use a lexical scan here?
-//   1. We can stop lexing as soon as we hit a non-exported token
-//   2. Lexing supports malformed modules, they'll throw at runtime instead of compilation
-//   3. `tdewolff/parse/v2` doesn't support TypeScript parsing yet, but we can lex it fine
+// Type-only declarations are erased by TypeScript, so they don't end the global slice.
+//
+// Anything that can't be tokenized is treated as part of the render body,
+// so malformed code will throw at runtime instead of during compilation.
 func FindRenderBody(source []byte) int {
-	l := js.NewLexer(parse.NewInputBytes(source))
-	i := 0
-	pairs := make(map[byte]int)
-
-	// Let's lex the script until we find what we need!
-	for {
-		token, value := l.Next()
-		openPairs := pairs['{'] > 0 || pairs['('] > 0 || pairs['['] > 0
-
-		if token == js.ErrorToken {
-			if l.Err() != io.EOF {
-				return -1
-			}
-			break
+	statements, _ := ParseStatements(source)
+	for _, statement := range statements {
+		if statement.Kind == StatementBody {
+			return statement.Start
 		}
-
-		// Common delimeters. Track their length, then skip.
-		if token == js.WhitespaceToken || token == js.LineTerminatorToken || token == js.SemicolonToken {
-			i += len(value)
-			continue
-		}
-
-		// Imports should be consumed up until we find a specifier,
-		// then we can exit after the following line terminator or semicolon
-		if token == js.ImportToken {
-			i += len(value)
-			foundSpecifier := false
-			foundAssertion := false
-			for {
-				next, nextValue := l.Next()
-				i += len(nextValue)
-				if next == js.StringToken {
-					foundSpecifier = true
-				}
-				if foundSpecifier && (next == js.LineTerminatorToken || next == js.SemicolonToken) && pairs['{'] == 0 && pairs['('] == 0 && pairs['['] == 0 {
-					break
-				}
-				if !foundAssertion && next == js.IdentifierToken && string(nextValue) == "assert" {
-					foundAssertion = true
-				}
-				if foundSpecifier {
-					if nextValue[0] == '{' || nextValue[0] == '(' || nextValue[0] == '[' {
-						pairs[nextValue[0]]++
-					} else if nextValue[0] == '}' {
-						pairs['{']--
-					} else if nextValue[0] == ')' {
-						pairs['(']--
-					} else if nextValue[0] == ']' {
-						pairs['[']--
-					}
-				}
-			}
-			continue
-		}
-
-		// Exports should be consumed until all opening braces are closed,
-		// a specifier is found, and a line terminator has been found
-		if token == js.ExportToken {
-			foundIdentifier := false
-			foundSemicolonOrLineTerminator := false
-			i += len(value)
-			for {
-				next, nextValue := l.Next()
-				i += len(nextValue)
-				if js.IsIdentifier(next) {
-					foundIdentifier = true
-				} else if next == js.LineTerminatorToken || next == js.SemicolonToken {
-					foundSemicolonOrLineTerminator = true
-				} else if js.IsPunctuator(next) {
-					if nextValue[0] == '{' || nextValue[0] == '(' || nextValue[0] == '[' {
-						pairs[nextValue[0]]++
-					} else if nextValue[0] == '}' {
-						pairs['{']--
-					} else if nextValue[0] == ')' {
-						pairs['(']--
-					} else if nextValue[0] == ']' {
-						pairs['[']--
-					}
-				}
-
-				if foundIdentifier && foundSemicolonOrLineTerminator && pairs['{'] == 0 && pairs['('] == 0 && pairs['['] == 0 {
-					break
-				}
-			}
-			continue
-		}
-
-		// Track opening and closing braces
-		if js.IsPunctuator(token) {
-			if value[0] == '{' || value[0] == '(' || value[0] == '[' {
-				pairs[value[0]]++
-				i += len(value)
-				continue
-			} else if value[0] == '}' {
-				pairs['{']--
-			} else if value[0] == ')' {
-				pairs['(']--
-			} else if value[0] == ']' {
-				pairs['[']--
-			}
-		}
-
-		// If there are no open pairs and we hit anything other than a comment
-		// return our index! This is the first non-exported declaration
-		if !openPairs && token != js.CommentToken {
-			return i
-		}
-
-		// Track our current position
-		i += len(value)
 	}
 
-	// If we haven't found anything... there's nothing to find! Split at the start.
-	return i
+	// If we haven't found anything... there's nothing to find! Split at the end.
+	return len(source)
 }
 
 func HasExports(source []byte) bool {
@@ -149,114 +43,53 @@ func HasExports(source []byte) bool {
 type HoistedScripts struct {
 	Hoisted [][]byte
 	Body    []byte
+	// The parts of the source that Body was copied from, in order
+	BodySpans []loc.Span
 }
 
+// Moves every top-level export out of the source, so that it can be printed
+// at the top level of the module instead of inside of the render function.
+// The module's default export is the component itself, so default exports
+// are left in place for transform to report.
 func HoistExports(source []byte) HoistedScripts {
-	shouldHoist := hasGetStaticPaths(source)
-	if !shouldHoist {
-		return HoistedScripts{
-			Body: source,
-		}
-	}
-
-	l := js.NewLexer(parse.NewInputBytes(source))
-	i := 0
-	pairs := make(map[byte]int)
-
-	// Let's lex the script until we find what we need!
-	for {
-		token, value := l.Next()
-
-		if token == js.ErrorToken {
-			if l.Err() != io.EOF {
-				return HoistedScripts{
-					Body: source,
-				}
-			}
-			break
-		}
-
-		// Common delimeters. Track their length, then skip.
-		if token == js.WhitespaceToken || token == js.LineTerminatorToken || token == js.SemicolonToken {
-			i += len(value)
+	statements, statementTokens, _ := parseStatements(source)
+	hoisted := make([][]byte, 1)
+	body := make([]byte, 0)
+	spans := make([]loc.Span, 0)
+	prev := 0
+	for i, statement := range statements {
+		if statement.Kind != StatementExport || exportsDefault(statementTokens[i]) {
 			continue
 		}
-
-		// Exports should be consumed until all opening braces are closed,
-		// a specifier is found, and a line terminator has been found
-		if token == js.ExportToken {
-			foundGetStaticPaths := false
-			foundSemicolonOrLineTerminator := false
-			start := i - 1
-			i += len(value)
-			for {
-				next, nextValue := l.Next()
-				i += len(nextValue)
-
-				if js.IsIdentifier(next) {
-					if !foundGetStaticPaths {
-						foundGetStaticPaths = string(nextValue) == "getStaticPaths"
-					}
-				} else if next == js.LineTerminatorToken || next == js.SemicolonToken {
-					foundSemicolonOrLineTerminator = true
-				} else if js.IsPunctuator(next) {
-					if nextValue[0] == '{' || nextValue[0] == '(' || nextValue[0] == '[' {
-						pairs[nextValue[0]]++
-					} else if nextValue[0] == '}' {
-						pairs['{']--
-					} else if nextValue[0] == ')' {
-						pairs['(']--
-					} else if nextValue[0] == ']' {
-						pairs['[']--
-					}
-				}
-
-				if next == js.ErrorToken {
-					return HoistedScripts{
-						Body: source,
-					}
-				}
-
-				if foundGetStaticPaths && foundSemicolonOrLineTerminator && pairs['{'] == 0 && pairs['('] == 0 && pairs['['] == 0 {
-					hoisted := make([][]byte, 1)
-					hoisted = append(hoisted, source[start:i])
-					body := make([]byte, 0)
-					body = append(body, source[0:start]...)
-					body = append(body, source[i:]...)
-					return HoistedScripts{
-						Hoisted: hoisted,
-						Body:    body,
-					}
-				}
-			}
+		// Take the line break before the export along with it
+		start := statement.Start
+		if start > prev && source[start-1] == '\n' {
+			start--
 		}
-
-		// Track opening and closing braces
-		if js.IsPunctuator(token) {
-			if value[0] == '{' || value[0] == '(' || value[0] == '[' {
-				pairs[value[0]]++
-				i += len(value)
-				continue
-			} else if value[0] == '}' {
-				pairs['{']--
-			} else if value[0] == ')' {
-				pairs['(']--
-			} else if value[0] == ']' {
-				pairs['[']--
-			}
+		hoisted = append(hoisted, source[start:statement.End])
+		body = append(body, source[prev:start]...)
+		if start > prev {
+			spans = append(spans, loc.Span{Start: prev, End: start})
+		}
+		prev = statement.End
+	}
+	if prev == 0 {
+		return HoistedScripts{
+			Body:      source,
+			BodySpans: []loc.Span{{Start: 0, End: len(source)}},
 		}
-
-		// Track our current position
-		i += len(value)
 	}
+	body = append(body, source[prev:]...)
+	spans = append(spans, loc.Span{Start: prev, End: len(source)})
 
-	// If we haven't found anything... there's nothing to find! Split at the start.
 	return HoistedScripts{
-		Body: source,
+		Hoisted:   hoisted,
+		Body:      body,
+		BodySpans: spans,
 	}
 }
 
-func hasGetStaticPaths(source []byte) bool {
+func AccessesPrivateVars(source []byte) bool {
 	l := js.NewLexer(parse.NewInputBytes(source))
 	for {
 		token, value := l.Next()
@@ -264,36 +97,85 @@ func hasGetStaticPaths(source []byte) bool {
 			// EOF or other error
 			return false
 		}
-		if token == js.IdentifierToken && string(value) == "getStaticPaths" {
+		if js.IsIdentifier(token) && len(value) > 1 && value[0] == '$' && value[1] == '$' {
 			return true
 		}
 	}
 }
 
-func AccessesPrivateVars(source []byte) bool {
-	l := js.NewLexer(parse.NewInputBytes(source))
-	for {
-		token, value := l.Next()
-		if token == js.ErrorToken {
-			// EOF or other error
-			return false
-		}
-		if js.IsIdentifier(token) && len(value) > 1 && value[0] == '$' && value[1] == '$' {
-			return true
-		}
+// A Token is a JavaScript token of the source, other than whitespace or a comment
+type Token struct {
+	Start        int
+	End          int
+	IsIdentifier bool
+}
+
+// Tokens returns the tokens of the source, up to the first one that can't be
+// lexed. Keywords that can also be used as names, like `async`, count as
+// identifiers.
+func Tokens(source []byte) []Token {
+	tokens, _, _ := tokenize(source[:len(source):len(source)])
+	result := make([]Token, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, Token{Start: t.start, End: t.start + len(t.value), IsIdentifier: t.tt != atToken && js.IsIdentifier(t.tt)})
 	}
+	return result
 }
 
 type Import struct {
 	ExportName string
 	LocalName  string
 	Assertions string
+	// `import { type A } from "a"`
+	IsType bool
+}
+
+type ImportKind uint32
+
+const (
+	// `import a from "a"`
+	ImportDeclaration ImportKind = iota
+	// `import("a")`
+	ImportDynamic
+	// `export { a } from "a"`
+	ImportReexport
+)
+
+func (k ImportKind) String() string {
+	switch k {
+	case ImportDynamic:
+		return "dynamic"
+	case ImportReexport:
+		return "reexport"
+	default:
+		return "import"
+	}
 }
 
 type ImportStatement struct {
+	Kind       ImportKind
 	Imports    []Import
 	Specifier  string
 	Assertions string
+	// `import type { A } from "a"`
+	IsType bool
+	// The range of the statement, or of the `import()` call for dynamic imports
+	Start int
+	End   int
+}
+
+// Type-only imports are erased by TypeScript, so they don't import anything at runtime.
+// Side-effect imports like `import "a"` have no bindings, but aren't type-only.
+func (s ImportStatement) IsTypeOnly() bool {
+	if s.IsType {
+		return true
+	}
+	for _, imported := range s.Imports {
+		if !imported.IsType {
+			return false
+		}
+	}
+	return len(s.Imports) > 0
 }
 
 type ImportState uint32
@@ -303,116 +185,187 @@ const (
 	ImportNamed
 )
 
+// Returns the position right after the next import declaration at or after pos,
+// or -1 if there are no more import declarations
 func NextImportStatement(source []byte, pos int) (int, ImportStatement) {
-	l := js.NewLexer(parse.NewInputBytes(source[pos:]))
-	i := pos
-	for {
-		token, value := l.Next()
-		if token == js.ErrorToken {
-			// EOF or other error
-			return -1, ImportStatement{}
+	for _, statement := range FindImports(source) {
+		if statement.Kind == ImportDeclaration && statement.Start >= pos {
+			return statement.End, statement
 		}
-		// Imports should be consumed up until we find a specifier,
-		// then we can exit after the following line terminator or semicolon
-		if token == js.ImportToken {
-			i += len(value)
-			specifier := ""
-			assertion := ""
-			foundSpecifier := false
-			foundAssertion := false
-			imports := make([]Import, 0)
-			importState := ImportDefault
-			currImport := Import{}
-			pairs := make(map[byte]int)
-			for {
-				next, nextValue := l.Next()
-				i += len(nextValue)
-
-				if !foundSpecifier && next == js.StringToken {
-					specifier = string(nextValue[1 : len(nextValue)-1])
-					foundSpecifier = true
-					continue
-				}
+	}
+	return -1, ImportStatement{}
+}
 
-				if foundSpecifier && (next == js.LineTerminatorToken || next == js.SemicolonToken) && pairs['{'] == 0 && pairs['('] == 0 && pairs['['] == 0 {
-					if currImport.ExportName != "" {
-						if currImport.LocalName == "" {
-							currImport.LocalName = currImport.ExportName
-						}
-						imports = append(imports, currImport)
-					}
-					return i, ImportStatement{
-						Imports:    imports,
-						Specifier:  specifier,
-						Assertions: assertion,
-					}
+// FindImports returns every module the frontmatter depends on, in source order:
+// import declarations, `export ... from` re-exports, and dynamic `import()` calls.
+// Dynamic imports are only included when their specifier is a string literal.
+func FindImports(source []byte) []ImportStatement {
+	statements, statementTokens, _ := parseStatements(source)
+	imports := make([]ImportStatement, 0)
+	for i, statement := range statements {
+		tokens := statementTokens[i]
+		if statement.Kind == StatementImport || statement.Kind == StatementExport && isReexport(tokens) {
+			// Re-exports have the same shape as imports after the first keyword
+			imported := parseImportStatement(source[statement.Start:statement.End:statement.End])
+			imported.Start = statement.Start
+			imported.End = statement.End
+			if statement.Kind == StatementExport {
+				imported.Kind = ImportReexport
+				// `export * from "a"` doesn't create a name of its own
+				if len(imported.Imports) == 1 && imported.Imports[0].LocalName == "*" {
+					imported.Imports = imported.Imports[:0]
 				}
+			}
+			imports = append(imports, imported)
+		}
 
-				if next == js.WhitespaceToken {
-					continue
-				}
+		for j := 0; j+3 < len(tokens); j++ {
+			if tokens[j].tt != js.ImportToken || tokens[j+1].tt != js.OpenParenToken {
+				continue
+			}
+			specifier := tokens[j+2]
+			if specifier.tt != js.StringToken && specifier.tt != js.TemplateToken {
+				continue
+			}
+			if tokens[j+3].tt != js.CloseParenToken && tokens[j+3].tt != js.CommaToken {
+				continue
+			}
+			// Unterminated calls run to the end of the statement
+			closing := matchingBracket(tokens, j+1)
+			if closing == len(tokens) {
+				closing--
+			}
+			imports = append(imports, ImportStatement{
+				Kind:      ImportDynamic,
+				Imports:   make([]Import, 0),
+				Specifier: string(specifier.value[1 : len(specifier.value)-1]),
+				Start:     tokens[j].start,
+				End:       tokens[closing].end(),
+			})
+		}
+	}
+	return imports
+}
 
-				if foundAssertion {
-					assertion += string(nextValue)
-				}
+func parseImportStatement(source []byte) ImportStatement {
+	l := js.NewLexer(parse.NewInputBytes(source))
+	// Skip the `import` keyword
+	l.Next()
+
+	specifier := ""
+	assertion := ""
+	foundSpecifier := false
+	foundAssertion := false
+	isType := false
+	imports := make([]Import, 0)
+	importState := ImportDefault
+	currImport := Import{}
+	// `type` is either a modifier or a name, which we only know from the token after it
+	maybeType := false
+	inBraces := false
+
+	addIdentifier := func(name string) {
+		if currImport.ExportName != "" {
+			currImport.LocalName = name
+		} else if importState == ImportNamed {
+			currImport.ExportName = name
+		} else if importState == ImportDefault {
+			currImport.ExportName = "default"
+			currImport.LocalName = name
+		}
+	}
 
-				if !foundAssertion && next == js.StringToken {
-					specifier = string(nextValue[1 : len(nextValue)-1])
-					foundSpecifier = true
-					continue
-				}
+	for {
+		next, nextValue := l.Next()
+		if next == js.ErrorToken {
+			break
+		}
 
-				if !foundAssertion && next == js.IdentifierToken && string(nextValue) == "assert" {
-					foundAssertion = true
-					continue
-				}
+		if !foundSpecifier && next == js.StringToken {
+			specifier = string(nextValue[1 : len(nextValue)-1])
+			foundSpecifier = true
+			continue
+		}
 
-				if !foundAssertion && next == js.OpenBraceToken {
-					importState = ImportNamed
-				}
+		if next == js.WhitespaceToken || next == js.SemicolonToken {
+			continue
+		}
 
-				if !foundAssertion && next == js.CommaToken {
-					if currImport.LocalName == "" {
-						currImport.LocalName = currImport.ExportName
-					}
-					imports = append(imports, currImport)
-					currImport = Import{}
+		if maybeType && next != js.LineTerminatorToken && next != js.CommentToken {
+			maybeType = false
+			if next == js.IdentifierToken || next == js.OpenBraceToken || next == js.MulToken {
+				if importState == ImportDefault && len(imports) == 0 {
+					isType = true
+				} else {
+					currImport.IsType = true
 				}
+			} else {
+				addIdentifier("type")
+			}
+		}
 
-				if !foundAssertion && next == js.IdentifierToken {
-					if currImport.ExportName != "" {
-						currImport.LocalName = string(nextValue)
-					} else if importState == ImportNamed {
-						currImport.ExportName = string(nextValue)
-					} else if importState == ImportDefault {
-						currImport.ExportName = "default"
-						currImport.LocalName = string(nextValue)
-					}
-				}
+		if foundAssertion {
+			assertion += string(nextValue)
+		}
 
-				if !foundAssertion && next == js.MulToken {
-					currImport.ExportName = string(nextValue)
-				}
+		if !foundAssertion && next == js.StringToken {
+			specifier = string(nextValue[1 : len(nextValue)-1])
+			foundSpecifier = true
+			continue
+		}
 
-				if js.IsPunctuator(next) {
-					if nextValue[0] == '{' || nextValue[0] == '(' || nextValue[0] == '[' {
-						pairs[nextValue[0]]++
-					} else if nextValue[0] == '}' {
-						pairs['{']--
-					} else if nextValue[0] == ')' {
-						pairs['(']--
-					} else if nextValue[0] == ']' {
-						pairs['[']--
-					}
-				}
+		if !foundAssertion && next == js.IdentifierToken && string(nextValue) == "assert" {
+			foundAssertion = true
+			continue
+		}
 
-				// if this is import.meta.*, ignore (watch for first dot)
-				if next == js.DotToken && len(specifier) == 0 {
-					break
-				}
+		if !foundAssertion && next == js.IdentifierToken && string(nextValue) == "type" && currImport.ExportName == "" && !isType {
+			maybeType = true
+			continue
+		}
+
+		if !foundAssertion && next == js.OpenBraceToken {
+			importState = ImportNamed
+			inBraces = true
+		}
+
+		if !foundAssertion && next == js.CloseBraceToken {
+			inBraces = false
+		}
+
+		if !foundAssertion && next == js.CommaToken {
+			if currImport.LocalName == "" {
+				currImport.LocalName = currImport.ExportName
 			}
+			imports = append(imports, currImport)
+			currImport = Import{}
 		}
 
-		i += len(value)
+		// Keywords like `default` are valid names inside of the braces
+		if !foundAssertion && (next == js.IdentifierToken || inBraces && next != js.AsToken && js.IsIdentifierName(next)) {
+			addIdentifier(string(nextValue))
+		}
+
+		if !foundAssertion && next == js.MulToken {
+			currImport.ExportName = string(nextValue)
+		}
+	}
+
+	if currImport.ExportName != "" {
+		if currImport.LocalName == "" {
+			currImport.LocalName = currImport.ExportName
+		}
+		imports = append(imports, currImport)
+	}
+	if isType {
+		for i := range imports {
+			imports[i].IsType = true
+		}
+	}
+	return ImportStatement{
+		Imports:    imports,
+		Specifier:  specifier,
+		Assertions: assertion,
+		IsType:     isType,
 	}
 }
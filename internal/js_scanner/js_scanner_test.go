@@ -146,6 +146,110 @@ import { c } from "c";
 			source: `let show = true;`,
 			want:   ``,
 		},
+		{
+			name: "export interface",
+			source: `import type { A } from "a";
+export interface Props {
+  a: A;
+  b: Array<{ c: string }>
+}
+const { a } = Astro.props;`,
+			want: `import type { A } from "a";
+export interface Props {
+  a: A;
+  b: Array<{ c: string }>
+}
+`,
+		},
+		{
+			name: "export multi-line type alias",
+			source: `export type Size =
+  | 'small'
+  | 'large'
+const size: Size = 'small'`,
+			want: `export type Size =
+  | 'small'
+  | 'large'
+`,
+		},
+		{
+			name: "export generic function",
+			source: `export function first<T extends { id: string }>(items: T[]): T {
+  return items[0]
+}
+const item = first([])`,
+			want: `export function first<T extends { id: string }>(items: T[]): T {
+  return items[0]
+}
+`,
+		},
+		{
+			name: "export with satisfies",
+			source: `export const config = {
+  prerender: true,
+}
+  satisfies Config
+const data = await fetch()`,
+			want: `export const config = {
+  prerender: true,
+}
+  satisfies Config
+`,
+		},
+		{
+			name: "export with object return type",
+			source: `export function getProps(): { title: string } {
+  return { title: "" }
+}
+const { title } = getProps()`,
+			want: `export function getProps(): { title: string } {
+  return { title: "" }
+}
+`,
+		},
+		{
+			name: "export decorated class",
+			source: `export @sealed
+class Store {
+  @observable
+  value = 0
+}
+const store = new Store()`,
+			want: `export @sealed
+class Store {
+  @observable
+  value = 0
+}
+`,
+		},
+		{
+			name: "type annotation in body",
+			source: `import { a } from "a";
+const b: Record<string, { c: number }> = {}
+import { d } from "d";`,
+			want: `import { a } from "a";
+`,
+		},
+		{
+			name: "type before import",
+			source: `type P = {
+  a: string
+}
+import b from "b";
+const c = b`,
+			want: `type P = {
+  a: string
+}
+import b from "b";
+`,
+		},
+		{
+			name: "dynamic import",
+			source: `import { a } from "a";
+import("b");`,
+			want: `import { a } from "a";
+`,
+		},
 		{
 			name: "RegExp is not a comment",
 			source: `import { a } from "a";
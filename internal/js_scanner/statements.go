@@ -0,0 +1,386 @@
+package js_scanner
+
+import (
+	"io"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+type StatementKind uint32
+
+const (
+	// Anything which has to run inside of the render function
+	StatementBody StatementKind = iota
+	StatementImport
+	StatementExport
+	// Declarations which only exist in the type system, like `type` and `interface`
+	StatementType
+)
+
+// A top-level statement of the frontmatter.
+// Start is the offset of the first token of the statement, so leading comments
+// and whitespace are not included. End is the offset right after its last token,
+// which includes the terminating semicolon if there is one.
+type Statement struct {
+	Kind  StatementKind
+	Start int
+	End   int
+}
+
+// tdewolff/parse doesn't know about decorators, so `@` gets a token type of its own
+const atToken js.TokenType = 0xffff
+
+type token struct {
+	tt    js.TokenType
+	value []byte
+	start int
+	// Whether a line terminator appears between this token and the previous one
+	newline bool
+}
+
+func (t token) is(value string) bool {
+	return t.tt == js.IdentifierToken && string(t.value) == value
+}
+
+func (t token) end() int {
+	return t.start + len(t.value)
+}
+
+// ParseStatements splits the frontmatter into top-level statements.
+//
+// This isn't a full parser! It only understands enough of JavaScript and
+// TypeScript to find where each statement starts and ends: brackets, automatic
+// semicolon insertion, blocks of declarations and control flow, and generic
+// type arguments in declaration headers. This keeps it fast and lets it accept
+// anything that esbuild would, since the output is handed to esbuild anyway.
+//
+// If the source can't be tokenized, everything from the offending token onward
+// is returned as a single StatementBody along with the error.
+func ParseStatements(source []byte) ([]Statement, error) {
+	statements, _, err := parseStatements(source)
+	return statements, err
+}
+
+// Like ParseStatements, but also returns the tokens of each statement
+func parseStatements(source []byte) ([]Statement, [][]token, error) {
+	tokens, errPos, err := tokenize(source)
+	statements := make([]Statement, 0)
+	statementTokens := make([][]token, 0)
+
+	for i := 0; i < len(tokens); {
+		// A stray semicolon after a block, like `function a() {};`, belongs to that block
+		if tokens[i].tt == js.SemicolonToken && len(statements) > 0 && !tokens[i].newline {
+			statements[len(statements)-1].End = tokens[i].end()
+			statementTokens[len(statementTokens)-1] = tokens[i-len(statementTokens[len(statementTokens)-1]) : i+1]
+			i++
+			continue
+		}
+		end := findStatementEnd(tokens, i)
+		statements = append(statements, Statement{
+			Kind:  classifyStatement(tokens, i),
+			Start: tokens[i].start,
+			End:   tokens[end].end(),
+		})
+		statementTokens = append(statementTokens, tokens[i:end+1])
+		i = end + 1
+	}
+
+	if err != nil {
+		statements = append(statements, Statement{
+			Kind:  StatementBody,
+			Start: errPos,
+			End:   len(source),
+		})
+		statementTokens = append(statementTokens, nil)
+	}
+	return statements, statementTokens, err
+}
+
+func tokenize(source []byte) ([]token, int, error) {
+	tokens := make([]token, 0)
+	base := 0
+	i := 0
+	newline := false
+	l := js.NewLexer(parse.NewInputBytes(source))
+
+	for {
+		tt, value := l.Next()
+		if tt == js.ErrorToken {
+			if l.Err() == io.EOF {
+				return tokens, i, nil
+			}
+			// Decorators are the only valid syntax the lexer rejects,
+			// so skip past the `@` and start lexing again
+			if i < len(source) && source[i] == '@' {
+				tokens = append(tokens, token{tt: atToken, value: source[i : i+1], start: i, newline: newline})
+				newline = false
+				i++
+				base = i
+				l = js.NewLexer(parse.NewInputBytes(source[base:]))
+				continue
+			}
+			return tokens, i, l.Err()
+		}
+
+		switch tt {
+		case js.WhitespaceToken, js.CommentToken:
+			i += len(value)
+			continue
+		case js.LineTerminatorToken, js.CommentLineTerminatorToken:
+			i += len(value)
+			newline = true
+			continue
+		case js.DivToken, js.DivEqToken:
+			if len(tokens) == 0 || !canEndExpression(tokens, len(tokens)-1) || (tokens[len(tokens)-1].tt == js.CloseBraceToken && newline) {
+				tt, value = l.RegExp()
+				if tt == js.ErrorToken {
+					return tokens, i, l.Err()
+				}
+			}
+		}
+
+		tokens = append(tokens, token{tt: tt, value: value, start: i, newline: newline})
+		newline = false
+		i += len(value)
+	}
+}
+
+func classifyStatement(tokens []token, i int) StatementKind {
+	for i < len(tokens) && tokens[i].tt == atToken {
+		i = skipDecorator(tokens, i)
+	}
+	if i >= len(tokens) {
+		return StatementBody
+	}
+	t := tokens[i]
+	var next *token
+	if i+1 < len(tokens) && !tokens[i+1].newline {
+		next = &tokens[i+1]
+	}
+
+	switch {
+	case t.tt == js.ImportToken:
+		// `import()` and `import.meta` are expressions
+		if i+1 < len(tokens) && (tokens[i+1].tt == js.OpenParenToken || tokens[i+1].tt == js.DotToken) {
+			return StatementBody
+		}
+		return StatementImport
+	case t.tt == js.ExportToken:
+		return StatementExport
+	case (t.is("type") || t.tt == js.InterfaceToken) && next != nil && js.IsIdentifierName(next.tt):
+		return StatementType
+	case t.is("declare") && next != nil && js.IsIdentifierName(next.tt):
+		return StatementType
+	}
+	return StatementBody
+}
+
+// Statements which end with a block, like functions, classes and control flow,
+// don't need a semicolon or a line break after their closing brace
+func isBlockStatement(tokens []token, i int) bool {
+	for ; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t.tt {
+		case js.ExportToken, js.DefaultToken, js.AsyncToken:
+			continue
+		case js.ConstToken:
+			if i+1 < len(tokens) && tokens[i+1].tt == js.EnumToken {
+				continue
+			}
+			return false
+		case js.IdentifierToken:
+			switch string(t.value) {
+			case "declare", "abstract":
+				continue
+			case "namespace", "module", "global":
+				return i+1 < len(tokens) && !tokens[i+1].newline && (js.IsIdentifierName(tokens[i+1].tt) || tokens[i+1].tt == js.StringToken || tokens[i+1].tt == js.OpenBraceToken)
+			}
+			return false
+		case js.FunctionToken, js.ClassToken, js.InterfaceToken, js.EnumToken, js.IfToken, js.ForToken, js.WhileToken, js.WithToken, js.TryToken, js.SwitchToken, js.DoToken:
+			return true
+		case js.OpenBraceToken:
+			return i == 0
+		case atToken:
+			i = skipDecorator(tokens, i) - 1
+			continue
+		}
+		return false
+	}
+	return false
+}
+
+func findStatementEnd(tokens []token, start int) int {
+	isBlock := isBlockStatement(tokens[start:], 0)
+	isDo := tokens[start].tt == js.DoToken
+	depth := 0
+	// Generic type arguments in a declaration header, like `interface A<T extends { a: 1 }> {}`
+	angles := 0
+	hasBody := false
+	// The `(` of `if (...)`, `for (...)`, `while (...)` and `with (...)` at each depth
+	headers := make([]bool, 0)
+
+	for i := start; i < len(tokens); i++ {
+		t := tokens[i]
+		isHeaderEnd := false
+		if t.tt == atToken && depth == 0 {
+			// Decorators can be followed by a line break, so skip right past them
+			i = skipDecorator(tokens, i) - 1
+			continue
+		}
+
+		switch t.tt {
+		case js.OpenParenToken, js.OpenBracketToken, js.OpenBraceToken, js.TemplateStartToken:
+			isHeader := false
+			if t.tt == js.OpenParenToken && depth == 0 && i > start {
+				switch tokens[i-1].tt {
+				case js.IfToken, js.ForToken, js.WithToken:
+					isHeader = true
+				case js.WhileToken:
+					// The `while (...)` of `do {} while (...)` ends the statement
+					isHeader = !isDo
+				case js.AwaitToken:
+					// for await (...)
+					isHeader = i-1 > start && tokens[i-2].tt == js.ForToken
+				}
+			}
+			if t.tt == js.OpenBraceToken && depth == 0 && angles == 0 {
+				hasBody = true
+			}
+			headers = append(headers, isHeader)
+			depth++
+		case js.CloseParenToken, js.CloseBracketToken, js.CloseBraceToken, js.TemplateEndToken:
+			if depth > 0 {
+				depth--
+				isHeaderEnd = headers[depth]
+				headers = headers[:depth]
+			}
+		case js.LtToken:
+			if isBlock && !hasBody && depth == 0 {
+				angles++
+			}
+		case js.GtToken, js.GtGtToken, js.GtGtGtToken:
+			if isBlock && !hasBody && depth == 0 {
+				angles -= len(t.value)
+				if angles < 0 {
+					angles = 0
+				}
+			}
+		}
+
+		if depth > 0 {
+			continue
+		}
+		if t.tt == js.SemicolonToken || i+1 == len(tokens) {
+			return i
+		}
+
+		next := tokens[i+1]
+		if isBlock && t.tt == js.CloseBraceToken && angles == 0 && !continuesBlock(next, isDo) {
+			return i
+		}
+		if next.newline && !isHeaderEnd && canEndExpression(tokens[start:], i-start) && !continuesExpression(next, isBlock, isDo) {
+			return i
+		}
+	}
+	return len(tokens) - 1
+}
+
+// Returns the index of the first token after the decorator at i, like `@a.b(c)`
+func skipDecorator(tokens []token, i int) int {
+	i++
+	for i < len(tokens) && (js.IsIdentifierName(tokens[i].tt) || tokens[i].tt == js.DotToken) {
+		i++
+	}
+	if i < len(tokens) && tokens[i].tt == js.OpenParenToken {
+		depth := 0
+		for ; i < len(tokens); i++ {
+			switch tokens[i].tt {
+			case js.OpenParenToken:
+				depth++
+			case js.CloseParenToken:
+				depth--
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// Words which are only ever followed by more of the same expression or type
+var continuationWords = map[string]bool{
+	"as":         true,
+	"satisfies":  true,
+	"from":       true,
+	"assert":     true,
+	"implements": true,
+	"keyof":      true,
+	"infer":      true,
+	"is":         true,
+	"readonly":   true,
+	"unique":     true,
+	"asserts":    true,
+}
+
+// Can the token at i be the last token of a statement?
+func canEndExpression(tokens []token, i int) bool {
+	t := tokens[i]
+	switch {
+	case t.tt == js.NotToken:
+		// `value!` is a TypeScript non-null assertion
+		return i > 0 && !t.newline && canEndExpression(tokens, i-1)
+	case t.tt == js.ConstToken:
+		// `value as const` is a TypeScript const assertion
+		return i > 0 && string(tokens[i-1].value) == "as"
+	case js.IsIdentifier(t.tt):
+		return !continuationWords[string(t.value)]
+	case js.IsNumeric(t.tt):
+		return true
+	}
+	switch t.tt {
+	case js.StringToken, js.TemplateToken, js.TemplateEndToken, js.RegExpToken, js.PrivateIdentifierToken,
+		js.CloseParenToken, js.CloseBracketToken, js.CloseBraceToken, js.IncrToken, js.DecrToken,
+		js.GtToken, js.GtGtToken, js.GtGtGtToken,
+		js.ThisToken, js.SuperToken, js.TrueToken, js.FalseToken, js.NullToken,
+		js.BreakToken, js.ContinueToken, js.ReturnToken, js.DebuggerToken:
+		return true
+	}
+	return false
+}
+
+// Does the token continue the statement before it, even after a line break?
+func continuesExpression(t token, isBlock bool, isDo bool) bool {
+	switch t.tt {
+	case js.IncrToken, js.DecrToken, js.NotToken, js.BitNotToken:
+		// These start a new statement after a line break
+		return false
+	case js.DotToken, js.OptChainToken, js.CommaToken, js.QuestionToken, js.ColonToken, js.ArrowToken,
+		js.OpenParenToken, js.OpenBracketToken, js.TemplateToken, js.TemplateStartToken,
+		js.InstanceofToken, js.InToken, js.ExtendsToken, js.ElseToken, js.CatchToken, js.FinallyToken:
+		return true
+	case js.OpenBraceToken:
+		return isBlock
+	case js.WhileToken:
+		return isDo
+	}
+	if js.IsOperator(t.tt) {
+		return true
+	}
+	return js.IsIdentifier(t.tt) && continuationWords[string(t.value)]
+}
+
+// Does the token continue a block statement after its closing brace?
+func continuesBlock(t token, isDo bool) bool {
+	switch t.tt {
+	case js.ElseToken, js.CatchToken, js.FinallyToken,
+		js.OpenBraceToken, js.DotToken, js.CommaToken, js.BitOrToken, js.BitAndToken, js.GtToken, js.ArrowToken:
+		return true
+	case js.OpenBracketToken:
+		return !t.newline
+	case js.WhileToken:
+		return isDo
+	}
+	return false
+}
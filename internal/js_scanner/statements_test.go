@@ -0,0 +1,239 @@
+package js_scanner
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+type statementcase struct {
+	Kind StatementKind
+	Text string
+}
+
+type importcase struct {
+	Kind      ImportKind
+	Specifier string
+	Imports   []Import
+	IsType    bool
+	Text      string
+}
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []statementcase
+	}{
+		{
+			name:   "semicolons",
+			source: `import a from "a"; const b = 1; export const c = 2;`,
+			want: []statementcase{
+				{StatementImport, `import a from "a";`},
+				{StatementBody, `const b = 1;`},
+				{StatementExport, `export const c = 2;`},
+			},
+		},
+		{
+			name: "line breaks",
+			source: `import a
+  from "a"
+const b = a
+  .map((c) => c)
+let c = b
+++c`,
+			want: []statementcase{
+				{StatementImport, "import a\n  from \"a\""},
+				{StatementBody, "const b = a\n  .map((c) => c)"},
+				{StatementBody, `let c = b`},
+				{StatementBody, `++c`},
+			},
+		},
+		{
+			name: "type only",
+			source: `type A<T> = {
+  a: T
+}
+interface B extends A<{ b: 1 }> {
+  c(): void
+}
+declare const d: B
+const type = 1`,
+			want: []statementcase{
+				{StatementType, "type A<T> = {\n  a: T\n}"},
+				{StatementType, "interface B extends A<{ b: 1 }> {\n  c(): void\n}"},
+				{StatementType, `declare const d: B`},
+				{StatementBody, `const type = 1`},
+			},
+		},
+		{
+			name: "control flow",
+			source: `if (a)
+  b()
+else {
+  c()
+}
+for (let i = 0; i < 1; i++) {}
+try {} catch {} finally {}
+do {} while (a)
+d()`,
+			want: []statementcase{
+				{StatementBody, "if (a)\n  b()\nelse {\n  c()\n}"},
+				{StatementBody, `for (let i = 0; i < 1; i++) {}`},
+				{StatementBody, `try {} catch {} finally {}`},
+				{StatementBody, `do {} while (a)`},
+				{StatementBody, `d()`},
+			},
+		},
+		{
+			name: "functions and classes",
+			source: `function a() {}
+(function b() {})()
+class C<T extends { d: 1 }> implements E<T> {}
+[1].forEach(a)`,
+			want: []statementcase{
+				{StatementBody, `function a() {}`},
+				{StatementBody, `(function b() {})()`},
+				{StatementBody, `class C<T extends { d: 1 }> implements E<T> {}`},
+				{StatementBody, `[1].forEach(a)`},
+			},
+		},
+		{
+			name:   "templates and regular expressions",
+			source: "const a = `${b}}` / 2\nconst c = /}`/g\nconst d = `${`}`}`",
+			want: []statementcase{
+				{StatementBody, "const a = `${b}}` / 2"},
+				{StatementBody, "const c = /}`/g"},
+				{StatementBody, "const d = `${`}`}`"},
+			},
+		},
+		{
+			name: "non-null assertion",
+			source: `const a = document.querySelector("a")!
+const b = a`,
+			want: []statementcase{
+				{StatementBody, `const a = document.querySelector("a")!`},
+				{StatementBody, `const b = a`},
+			},
+		},
+		{
+			name: "const assertion",
+			source: `export const SIZES = ['sm', 'lg'] as const
+const { size } = Astro.props
+export const b = {
+} as const
+c()`,
+			want: []statementcase{
+				{StatementExport, `export const SIZES = ['sm', 'lg'] as const`},
+				{StatementBody, `const { size } = Astro.props`},
+				{StatementExport, "export const b = {\n} as const"},
+				{StatementBody, `c()`},
+			},
+		},
+		{
+			name: "import expressions",
+			source: `import.meta.env.A
+import("a")
+import type { B } from "b"`,
+			want: []statementcase{
+				{StatementBody, `import.meta.env.A`},
+				{StatementBody, `import("a")`},
+				{StatementImport, `import type { B } from "b"`},
+			},
+		},
+		{
+			name: "decorators",
+			source: `@a.b()
+@c
+class D {}
+e()`,
+			want: []statementcase{
+				{StatementBody, "@a.b()\n@c\nclass D {}"},
+				{StatementBody, `e()`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statements, err := ParseStatements([]byte(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			got := make([]statementcase, 0)
+			for _, statement := range statements {
+				got = append(got, statementcase{statement.Kind, tt.source[statement.Start:statement.End]})
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestNextImportStatement(t *testing.T) {
+	source := `import a from "a";
+const b = import("b")
+import {
+  c,
+  d as e,
+} from "c" assert { type: "json" }
+import * as f from "f"
+import type { G } from "g"
+import type H, { I } from "h"
+import { type J, K, type L as M } from "j"
+import type from "type"
+import type, { type } from "type"
+import { default as N, get } from "n"`
+	want := []ImportStatement{
+		{Specifier: "a", Imports: []Import{{ExportName: "default", LocalName: "a"}}},
+		{Specifier: "c", Imports: []Import{{ExportName: "c", LocalName: "c"}, {ExportName: "d", LocalName: "e"}}, Assertions: `{type:"json"}`},
+		{Specifier: "f", Imports: []Import{{ExportName: "*", LocalName: "f"}}},
+		{Specifier: "g", Imports: []Import{{ExportName: "G", LocalName: "G", IsType: true}}, IsType: true},
+		{Specifier: "h", Imports: []Import{{ExportName: "default", LocalName: "H", IsType: true}, {ExportName: "I", LocalName: "I", IsType: true}}, IsType: true},
+		{Specifier: "j", Imports: []Import{{ExportName: "J", LocalName: "J", IsType: true}, {ExportName: "K", LocalName: "K"}, {ExportName: "L", LocalName: "M", IsType: true}}},
+		{Specifier: "type", Imports: []Import{{ExportName: "default", LocalName: "type"}}},
+		{Specifier: "type", Imports: []Import{{ExportName: "default", LocalName: "type"}, {ExportName: "type", LocalName: "type"}}},
+		{Specifier: "n", Imports: []Import{{ExportName: "default", LocalName: "N"}, {ExportName: "get", LocalName: "get"}}},
+	}
+	got := make([]ImportStatement, 0)
+	pos, statement := NextImportStatement([]byte(source), 0)
+	for pos != -1 {
+		// Locations are covered by TestFindImports
+		statement.Start, statement.End = 0, 0
+		got = append(got, statement)
+		pos, statement = NextImportStatement([]byte(source), pos)
+	}
+	if diff := test_utils.ANSIDiff(want, got); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+}
+
+func TestFindImports(t *testing.T) {
+	source := `import a from "a";
+export { b, c as d } from "b"
+export * as e from "e"
+export type { F } from "f"
+const g = await import("g")
+const h = import(` + "`h`" + `, { assert: { type: "json" } })
+const i = import(i)
+export * from "j"
+const k = import("k",`
+	want := []importcase{
+		{Kind: ImportDeclaration, Specifier: "a", Imports: []Import{{ExportName: "default", LocalName: "a"}}, Text: `import a from "a";`},
+		{Kind: ImportReexport, Specifier: "b", Imports: []Import{{ExportName: "b", LocalName: "b"}, {ExportName: "c", LocalName: "d"}}, Text: `export { b, c as d } from "b"`},
+		{Kind: ImportReexport, Specifier: "e", Imports: []Import{{ExportName: "*", LocalName: "e"}}, Text: `export * as e from "e"`},
+		{Kind: ImportReexport, Specifier: "f", Imports: []Import{{ExportName: "F", LocalName: "F", IsType: true}}, IsType: true, Text: `export type { F } from "f"`},
+		{Kind: ImportDynamic, Specifier: "g", Imports: []Import{}, Text: `import("g")`},
+		{Kind: ImportDynamic, Specifier: "h", Imports: []Import{}, Text: "import(`h`, { assert: { type: \"json\" } })"},
+		{Kind: ImportReexport, Specifier: "j", Imports: []Import{}, Text: `export * from "j"`},
+		{Kind: ImportDynamic, Specifier: "k", Imports: []Import{}, Text: `import("k",`},
+	}
+	got := make([]importcase, 0)
+	for _, imported := range FindImports([]byte(source)) {
+		got = append(got, importcase{Kind: imported.Kind, Specifier: imported.Specifier, Imports: imported.Imports, IsType: imported.IsType, Text: source[imported.Start:imported.End]})
+	}
+	if diff := test_utils.ANSIDiff(want, got); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+}
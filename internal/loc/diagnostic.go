@@ -0,0 +1,25 @@
+package loc
+
+type DiagnosticSeverity uint32
+
+const (
+	SeverityError DiagnosticSeverity = iota
+	SeverityWarning
+)
+
+func (s DiagnosticSeverity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+// A Diagnostic is a problem in the source that doesn't stop compilation.
+// Range points at the offending code in the original source.
+type Diagnostic struct {
+	Severity DiagnosticSeverity
+	Text     string
+	Range    Range
+}
@@ -6,6 +6,7 @@ package astro
 
 import (
 	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/internal/sourcemap"
 	"golang.org/x/net/html/atom"
 )
 
@@ -61,6 +62,10 @@ type Node struct {
 	HydratedComponents   []*Node
 	ClientOnlyComponents []*Node
 	HydrationDirectives  map[string]bool
+	StyleImports         []StyleImport
+	Diagnostics          []loc.Diagnostic
+	// Import specifiers mapped to the files they resolve to on disk
+	ResolvedSpecifiers map[string]string
 
 	Type      NodeType
 	DataAtom  atom.Atom
@@ -68,6 +73,34 @@ type Node struct {
 	Namespace string
 	Attr      []Attribute
 	Loc       []loc.Loc
+	// Set on the text of a <style> or <script> that a preprocessor rewrote,
+	// so the final source map can still point at what the user wrote. The
+	// SourceMappings lead to the preprocessor's output, which InputSourceMap
+	// maps back to the source.
+	SourceMappings []SourceMapping
+	InputSourceMap *sourcemap.InputSourceMap
+}
+
+// A SourceMapping ties a byte offset in the Data of a node to the offset in
+// the code of its InputSourceMap that it was copied from. Offsets after it,
+// up to the next mapping, were copied along with it.
+type SourceMapping struct {
+	Generated int
+	Original  int
+}
+
+// A StyleImport is an `@import` rule found inside a component's <style>.
+// Loc points at the `@` of the rule in the original source.
+type StyleImport struct {
+	Specifier string
+	Media     string
+	Loc       loc.Loc
+
+	// Resolved imports have been removed from the CSS and are printed as
+	// JS imports instead. Scoped imports ask the bundler to scope the
+	// imported file with the component's hash.
+	Resolved bool
+	Scoped   bool
 }
 
 // InsertBefore inserts newChild as a child of n, immediately before oldChild
@@ -26,8 +26,7 @@ func PrintCSS(sourcetext string, doc *Node, opts transform.TransformOptions) Pri
 	if len(doc.Styles) > 0 {
 		for _, style := range doc.Styles {
 			if style.FirstChild != nil && strings.TrimSpace(style.FirstChild.Data) != "" {
-				p.addSourceMapping(style.Loc[0])
-				p.print(escapeText(strings.TrimSpace(style.FirstChild.Data)))
+				p.printMappedText(style.FirstChild, style.Loc[0])
 				result.Output = append(result.Output, p.output)
 				p.output = []byte{}
 				p.addNilSourceMapping()
@@ -37,3 +36,19 @@ func PrintCSS(sourcetext string, doc *Node, opts transform.TransformOptions) Pri
 
 	return result
 }
+
+// Returns the styles that are added to `$$result.styles` at runtime. With
+// StaticExtraction only the scoped `define:vars` rules remain, since their
+// values depend on the component instance.
+func runtimeStyles(doc *Node, opts transform.TransformOptions) []*Node {
+	if !opts.StaticExtraction {
+		return doc.Styles
+	}
+	styles := make([]*Node, 0, len(doc.Styles))
+	for _, node := range doc.Styles {
+		if transform.IsScopedStyleVars(node) {
+			styles = append(styles, node)
+		}
+	}
+	return styles
+}
@@ -0,0 +1,100 @@
+package printer
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/sourcemap"
+	"github.com/withastro/compiler/internal/transform"
+)
+
+// A HoistedScript is one of the entries of `$$metadata.hoisted`
+type HoistedScript struct {
+	// Either "inline" or "remote"
+	Type string
+	Src  string
+	// The `lang` attribute, or "js" when there isn't one
+	Lang string
+	// Every attribute of the <script>. Hoisted scripts are bundled rather than
+	// rendered, so the values of expression attributes are never known.
+	Attrs []Attribute
+	Code  []byte
+	// Maps Code back to the component source. Remote scripts have no code,
+	// so this is left empty for them.
+	SourceMapChunk sourcemap.Chunk
+}
+
+// PrintHoistedScripts returns every hoisted script, in the same order as
+// `$$metadata.hoisted`. The bundler loads inline scripts as modules of their
+// own, so each of them gets a source map of its own.
+func PrintHoistedScripts(sourcetext string, doc *Node) []HoistedScript {
+	nodes := hoistedScripts(doc)
+	scripts := make([]HoistedScript, 0, len(nodes))
+	var lineOffsetTables []sourcemap.LineOffsetTable
+	for _, node := range nodes {
+		if src := GetAttribute(node, "src"); src != nil {
+			scripts = append(scripts, HoistedScript{Type: "remote", Src: src.Val, Lang: scriptLang(node), Attrs: node.Attr})
+			continue
+		}
+		if lineOffsetTables == nil {
+			lineOffsetTables = sourcemap.GenerateLineOffsetTables(sourcetext, len(strings.Split(sourcetext, "\n")))
+		}
+		p := &printer{builder: sourcemap.MakeChunkBuilder(nil, lineOffsetTables)}
+		start := 0
+		if len(node.FirstChild.Loc) > 0 {
+			start = node.FirstChild.Loc[0].Start
+		}
+		p.printCode(node.FirstChild.Data, start)
+		scripts = append(scripts, HoistedScript{
+			Type:           "inline",
+			Lang:           scriptLang(node),
+			Attrs:          node.Attr,
+			Code:           p.output,
+			SourceMapChunk: p.builder.GenerateChunk(p.output),
+		})
+	}
+	return scripts
+}
+
+// Returns the hoisted scripts that are listed in `$$metadata.hoisted`.
+// Inline scripts without any content are left out.
+func hoistedScripts(doc *Node) []*Node {
+	scripts := make([]*Node, 0, len(doc.Scripts))
+	for _, node := range doc.Scripts {
+		if GetAttribute(node, "src") != nil || node.FirstChild != nil {
+			scripts = append(scripts, node)
+		}
+	}
+	return scripts
+}
+
+// Returns the scripts that are added to `$$result.scripts` at runtime. Inline
+// scripts imported by StaticScriptExtraction are left to the bundler.
+func runtimeScripts(doc *Node, opts transform.TransformOptions) []*Node {
+	if !opts.StaticScriptExtraction {
+		return doc.Scripts
+	}
+	scripts := make([]*Node, 0, len(doc.Scripts))
+	for _, node := range doc.Scripts {
+		if GetAttribute(node, "src") != nil {
+			scripts = append(scripts, node)
+		}
+	}
+	return scripts
+}
+
+func scriptLang(node *Node) string {
+	if lang := GetAttribute(node, "lang"); lang != nil && lang.Val != "" {
+		return lang.Val
+	}
+	return "js"
+}
+
+// ScriptModuleID returns the id of the virtual module that the bundler loads
+// an inline hoisted script from, which StaticScriptExtraction imports. index
+// is the position of the script in `$$metadata.hoisted`.
+func ScriptModuleID(filename string, index int, lang string) string {
+	// import '/src/pages/index.astro?astro&type=script&index=0&lang.ts';
+	return fmt.Sprintf("%s?astro&type=script&index=%d&lang.%s", filename, index, lang)
+}
@@ -5,7 +5,6 @@
 package printer
 
 import (
-	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -72,6 +71,12 @@ type ExtractedStatement struct {
 }
 
 func printToJs(p *printer, n *Node, cssLen int, opts transform.TransformOptions) PrintResult {
+	p.hasScopedStyleVars = transform.HasScopedStyleVars(n)
+	p.componentName = transform.ComponentName(n, opts)
+	// Unlike the other helpers, Fragment can be used by name in the component's own code
+	if transform.ReferencesUndeclared(n, FRAGMENT) {
+		p.helper(FRAGMENT)
+	}
 	render1(p, n, RenderOptions{
 		cssLen:       cssLen,
 		isRoot:       true,
@@ -80,9 +85,10 @@ func printToJs(p *printer, n *Node, cssLen int, opts transform.TransformOptions)
 		opts:         opts,
 	})
 
+	output, chunk := p.prependInternalImports(p.output, p.builder.GenerateChunk(p.output))
 	return PrintResult{
-		Output:         p.output,
-		SourceMapChunk: p.builder.GenerateChunk(p.output),
+		Output:         output,
+		SourceMapChunk: chunk,
 	}
 }
 
@@ -95,6 +101,10 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		if opts.opts.StaticExtraction {
 			p.printCSSImports(opts.cssLen)
 		}
+		if opts.opts.StaticScriptExtraction {
+			p.printScriptImports(n)
+		}
+		p.printStyleImports(n)
 
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
 			render1(p, c, RenderOptions{
@@ -106,8 +116,8 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		}
 
 		p.printReturnClose()
-		// TODO: use proper component name
-		p.printFuncSuffix("$$Component")
+		p.printFuncSuffix(p.componentName)
+		p.printStaticSubtrees(opts.opts)
 		return
 	}
 
@@ -119,76 +129,53 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 				if opts.opts.StaticExtraction {
 					p.printCSSImports(opts.cssLen)
 				}
+				if opts.opts.StaticScriptExtraction {
+					p.printScriptImports(n.Parent)
+				}
+				p.printStyleImports(n.Parent)
 
 				// This scanner returns a position where we should slice the frontmatter.
-				// If it encounters any `await`ed code or code that accesses the `Astro` global,
-				// `renderBodyStart` will be the index where we should split the frontmatter.
-				// If we don't encounter any of those, `renderBodyStart` will be `-1`
+				// Everything before it is imports, exports and types, which are printed at the
+				// top level of the module. Everything after it goes inside of the render function.
 				renderBodyStart := js_scanner.FindRenderBody([]byte(c.Data))
 				if len(n.Loc) > 0 {
 					p.addSourceMapping(n.Loc[0])
 				}
-				if renderBodyStart == -1 {
-					if len(c.Loc) > 0 {
-						p.addSourceMapping(c.Loc[0])
-					}
-					preprocessed := js_scanner.HoistExports([]byte(c.Data))
-
-					// 1. After imports put in the top-level Astro.
-					p.printTopLevelAstro()
+				importStatements := c.Data[0:renderBodyStart]
+				content := c.Data[renderBodyStart:]
+				preprocessed := js_scanner.HoistExports([]byte(content))
 
-					if len(preprocessed.Hoisted) > 0 {
-						for _, hoisted := range preprocessed.Hoisted {
-							p.println(strings.TrimSpace(string(hoisted)))
-						}
-					}
-
-					// 2. The frontmatter.
-					p.print(strings.TrimSpace(c.Data))
-
-					// 3. The metadata object
-					p.printComponentMetadata(n.Parent, opts.opts, []byte(c.Data))
-
-					// TODO: use the proper component name
-					p.printFuncPrelude("$$Component")
+				if len(c.Loc) > 0 {
+					p.printTrimmedCode(importStatements, c.Loc[0].Start)
+					p.println("")
 				} else {
-					importStatements := c.Data[0:renderBodyStart]
-					content := c.Data[renderBodyStart:]
-					preprocessed := js_scanner.HoistExports([]byte(content))
-					renderBody := preprocessed.Body
-
-					if js_scanner.HasExports(renderBody) {
-						panic(errors.New("Export statements must be placed at the top of .astro files!"))
-					}
-					if len(c.Loc) > 0 {
-						p.addSourceMapping(c.Loc[0])
-					}
 					p.println(strings.TrimSpace(importStatements))
+				}
 
-					// 1. Component imports, if any exist.
-					p.printComponentMetadata(n.Parent, opts.opts, []byte(importStatements))
-					// 2. Top-level Astro global.
-					p.printTopLevelAstro()
+				// 1. Component imports, if any exist.
+				p.printComponentMetadata(n.Parent, opts.opts, []byte(c.Data))
+				// 2. Top-level Astro global.
+				p.printTopLevelAstro()
 
-					if len(preprocessed.Hoisted) > 0 {
-						for _, hoisted := range preprocessed.Hoisted {
-							p.println(strings.TrimSpace(string(hoisted)))
-						}
+				if len(preprocessed.Hoisted) > 0 {
+					for _, hoisted := range preprocessed.Hoisted {
+						p.println(strings.TrimSpace(string(hoisted)))
 					}
+				}
 
-					// TODO: use the proper component name
-					p.printFuncPrelude("$$Component")
-					if len(c.Loc) > 0 {
-						p.addSourceMapping(loc.Loc{Start: c.Loc[0].Start + renderBodyStart})
-					}
+				p.printFuncPrelude(p.componentName)
+				if len(c.Loc) > 0 {
+					p.printCodeSpans(content, preprocessed.BodySpans, c.Loc[0].Start+renderBodyStart)
+				} else {
 					p.print(strings.TrimSpace(string(preprocessed.Body)))
 				}
 
 				// Print empty just to ensure a newline
 				p.println("")
-				if !opts.opts.StaticExtraction && len(n.Parent.Styles) > 0 {
+				p.printStyleVarsID()
+				if styles := runtimeStyles(n.Parent, opts.opts); len(styles) > 0 {
 					p.println("const STYLES = [")
-					for _, style := range n.Parent.Styles {
+					for _, style := range styles {
 						p.printStyleOrScript(style)
 					}
 					p.println("];")
@@ -196,9 +183,9 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 					p.println(fmt.Sprintf("for (const STYLE of STYLES) %s.styles.add(STYLE);", RESULT))
 				}
 
-				if len(n.Parent.Scripts) > 0 {
+				if scripts := runtimeScripts(n.Parent, opts.opts); len(scripts) > 0 {
 					p.println("const SCRIPTS = [")
-					for _, script := range n.Parent.Scripts {
+					for _, script := range scripts {
 						p.printStyleOrScript(script)
 					}
 					p.println("];")
@@ -223,24 +210,24 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		p.printTopLevelAstro()
 
 		// Render func prelude. Will only run for the first non-frontmatter node
-		// TODO: use the proper component name
-		p.printFuncPrelude("$$Component")
+		p.printFuncPrelude(p.componentName)
 		// This just ensures a newline
 		p.println("")
+		p.printStyleVarsID()
 
 		// If we haven't printed the funcPrelude but we do have Styles/Scripts, we need to print them!
-		if !opts.opts.StaticExtraction && len(n.Parent.Styles) > 0 {
+		if styles := runtimeStyles(n.Parent, opts.opts); len(styles) > 0 {
 			p.println("const STYLES = [")
-			for _, style := range n.Parent.Styles {
+			for _, style := range styles {
 				p.printStyleOrScript(style)
 			}
 			p.println("];")
 			p.addNilSourceMapping()
 			p.println(fmt.Sprintf("for (const STYLE of STYLES) %s.styles.add(STYLE);", RESULT))
 		}
-		if len(n.Parent.Scripts) > 0 {
+		if scripts := runtimeScripts(n.Parent, opts.opts); len(scripts) > 0 {
 			p.println("const SCRIPTS = [")
-			for _, script := range n.Parent.Scripts {
+			for _, script := range scripts {
 				p.printStyleOrScript(script)
 			}
 			p.println("];")
@@ -250,6 +237,10 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 
 		p.printReturnOpen()
 	}
+	if p.shouldHoistStaticSubtree(n, opts.opts) {
+		p.printStaticSubtreeReference(n)
+		return
+	}
 	switch n.Type {
 	case TextNode:
 		if strings.TrimSpace(n.Data) == "" {
@@ -310,11 +301,11 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 		}
 
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			p.addSourceMapping(c.Loc[0])
 			if c.Type == TextNode {
-				p.print(c.Data)
+				p.printCode(c.Data, c.Loc[0].Start)
 				continue
 			}
+			p.addSourceMapping(c.Loc[0])
 			if c.PrevSibling == nil || c.PrevSibling.Type == TextNode {
 				p.printTemplateLiteralOpen()
 			}
@@ -343,11 +334,11 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 	p.addSourceMapping(n.Loc[0])
 	switch true {
 	case isFragment:
-		p.print(fmt.Sprintf("${%s(%s,'%s',", RENDER_COMPONENT, RESULT, "Fragment"))
+		p.print(fmt.Sprintf("${%s(%s,'%s',", p.helper(RENDER_COMPONENT), RESULT, "Fragment"))
 	case isComponent:
-		p.print(fmt.Sprintf("${%s(%s,'%s',", RENDER_COMPONENT, RESULT, n.Data))
+		p.print(fmt.Sprintf("${%s(%s,'%s',", p.helper(RENDER_COMPONENT), RESULT, n.Data))
 	case isSlot:
-		p.print(fmt.Sprintf("${%s(%s,%s[", RENDER_SLOT, RESULT, SLOTS))
+		p.print(fmt.Sprintf("${%s(%s,%s[", p.helper(RENDER_SLOT), RESULT, SLOTS))
 	default:
 		p.print("<")
 
@@ -356,7 +347,7 @@ func render1(p *printer, n *Node, opts RenderOptions) {
 	p.addSourceMapping(loc.Loc{Start: n.Loc[0].Start + 1})
 	switch true {
 	case isFragment:
-		p.print("Fragment")
+		p.print(p.helper(FRAGMENT))
 	case isClientOnly:
 		p.print("null")
 	case !isSlot && n.CustomElement:
@@ -3,6 +3,7 @@ package printer
 import (
 	"fmt"
 	"strings"
+	"unicode"
 
 	astro "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/js_scanner"
@@ -20,25 +21,39 @@ type PrintResult struct {
 type printer struct {
 	opts               transform.TransformOptions
 	output             []byte
+	componentName      string
 	builder            sourcemap.ChunkBuilder
 	hasFuncPrelude     bool
+	internalURL        string
+	usedHelpers        map[string]bool
 	hasInternalImports bool
+	staticSubtrees     []*astro.Node
 	hasCSSImports      bool
+	hasScriptImports   bool
+	hasStyleImports    bool
+	hasScopedStyleVars bool
+	// Set while the static subtrees themselves are printed
+	printingStaticSubtrees bool
 }
 
-var TEMPLATE_TAG = "$$render"
-var CREATE_ASTRO = "$$createAstro"
-var CREATE_COMPONENT = "$$createComponent"
-var RENDER_COMPONENT = "$$renderComponent"
-var RENDER_SLOT = "$$renderSlot"
-var ADD_ATTRIBUTE = "$$addAttribute"
-var SPREAD_ATTRIBUTES = "$$spreadAttributes"
-var DEFINE_STYLE_VARS = "$$defineStyleVars"
-var DEFINE_SCRIPT_VARS = "$$defineScriptVars"
-var CREATE_METADATA = "$$createMetadata"
-var METADATA = "$$metadata"
-var RESULT = "$$result"
-var SLOTS = "$$slots"
+// The names are defined by transform, which reports user code that uses them
+var TEMPLATE_TAG = transform.TemplateTag
+var CREATE_ASTRO = transform.CreateAstro
+var CREATE_COMPONENT = transform.CreateComponent
+var RENDER_COMPONENT = transform.RenderComponent
+var RENDER_SLOT = transform.RenderSlot
+var ADD_ATTRIBUTE = transform.AddAttribute
+var SPREAD_ATTRIBUTES = transform.SpreadAttributes
+var DEFINE_STYLE_VARS = transform.DefineStyleVars
+var DEFINE_SCRIPT_VARS = transform.DefineScriptVars
+var CREATE_METADATA = transform.CreateMetadata
+var METADATA = transform.Metadata
+var RESULT = transform.Result
+var SLOTS = transform.Slots
+var PROPS = transform.Props
+var ASTRO = transform.TopLevelAstro
+var MODULE = transform.ModulePrefix
+var STYLE_VARS_COUNT = transform.StyleVarsCount
 var FRAGMENT = "Fragment"
 var BACKTICK = "`"
 
@@ -50,28 +65,72 @@ func (p *printer) println(text string) {
 	p.output = append(p.output, (text + "\n")...)
 }
 
+// The runtime helpers, in the order they are imported, and the names they are
+// exported under
+var internalHelpers = []struct{ local, exported string }{
+	{FRAGMENT, FRAGMENT},
+	{TEMPLATE_TAG, "render"},
+	{CREATE_ASTRO, "createAstro"},
+	{CREATE_COMPONENT, "createComponent"},
+	{RENDER_COMPONENT, "renderComponent"},
+	{RENDER_SLOT, "renderSlot"},
+	{ADD_ATTRIBUTE, "addAttribute"},
+	{SPREAD_ATTRIBUTES, "spreadAttributes"},
+	{DEFINE_STYLE_VARS, "defineStyleVars"},
+	{DEFINE_SCRIPT_VARS, "defineScriptVars"},
+	{CREATE_METADATA, "createMetadata"},
+}
+
+// Which helpers the component needs is only known once all of it has been
+// printed, so this only marks where the imports go. They are added by
+// prependInternalImports.
 func (p *printer) printInternalImports(importSpecifier string) {
 	if p.hasInternalImports {
 		return
 	}
-	p.print("import {\n  ")
-	p.print(FRAGMENT + ",\n  ")
-	p.print("render as " + TEMPLATE_TAG + ",\n  ")
-	p.print("createAstro as " + CREATE_ASTRO + ",\n  ")
-	p.print("createComponent as " + CREATE_COMPONENT + ",\n  ")
-	p.print("renderComponent as " + RENDER_COMPONENT + ",\n  ")
-	p.print("renderSlot as " + RENDER_SLOT + ",\n  ")
-	p.print("addAttribute as " + ADD_ATTRIBUTE + ",\n  ")
-	p.print("spreadAttributes as " + SPREAD_ATTRIBUTES + ",\n  ")
-	p.print("defineStyleVars as " + DEFINE_STYLE_VARS + ",\n  ")
-	p.print("defineScriptVars as " + DEFINE_SCRIPT_VARS + ",\n  ")
-	p.print("createMetadata as " + CREATE_METADATA)
-	p.print("\n} from \"")
-	p.print(importSpecifier)
-	p.print("\";\n")
+	p.internalURL = importSpecifier
 	p.hasInternalImports = true
 }
 
+// Returns the local name of a runtime helper, and records that it needs to
+// be imported
+func (p *printer) helper(name string) string {
+	if p.usedHelpers == nil {
+		p.usedHelpers = make(map[string]bool)
+	}
+	p.usedHelpers[name] = true
+	return name
+}
+
+// Adds the imports of the helpers the printed code uses to the start of the
+// output. Nothing is printed before them, so the source map only needs an
+// empty line for each line of the imports.
+func (p *printer) prependInternalImports(output []byte, chunk sourcemap.Chunk) ([]byte, sourcemap.Chunk) {
+	if !p.hasInternalImports {
+		return output, chunk
+	}
+	specifiers := make([]string, 0, len(internalHelpers))
+	for _, h := range internalHelpers {
+		if !p.usedHelpers[h.local] {
+			continue
+		}
+		if h.local == h.exported {
+			specifiers = append(specifiers, h.local)
+		} else {
+			specifiers = append(specifiers, h.exported+" as "+h.local)
+		}
+	}
+	if len(specifiers) == 0 {
+		return output, chunk
+	}
+
+	imports := fmt.Sprintf("import {\n  %s\n} from \"%s\";\n", strings.Join(specifiers, ",\n  "), p.internalURL)
+	lines := strings.Count(imports, "\n")
+	chunk.Buffer = append([]byte(strings.Repeat(";", lines)), chunk.Buffer...)
+	chunk.EndState.GeneratedLine += lines
+	return append([]byte(imports), output...), chunk
+}
+
 func (p *printer) printCSSImports(cssLen int) {
 	if p.hasCSSImports {
 		return
@@ -86,6 +145,55 @@ func (p *printer) printCSSImports(cssLen int) {
 	p.hasCSSImports = true
 }
 
+// Import every inline hoisted script as a module of its own, so the bundler
+// processes it like any other client module. These imports are for the
+// client build: during SSR the bundler plugin has to resolve them to an
+// empty module, or the client code would run on the server.
+func (p *printer) printScriptImports(doc *astro.Node) {
+	if p.hasScriptImports {
+		return
+	}
+	printed := false
+	for i, node := range hoistedScripts(doc) {
+		if astro.GetAttribute(node, "src") != nil {
+			continue
+		}
+		p.print(fmt.Sprintf("import %s;", quoteString(ScriptModuleID(p.opts.Filename, i, scriptLang(node)))))
+		printed = true
+	}
+	if printed {
+		p.print("\n")
+	}
+	p.hasScriptImports = true
+}
+
+func (p *printer) printStyleImports(doc *astro.Node) {
+	if p.hasStyleImports {
+		return
+	}
+	printed := false
+	for _, imported := range doc.StyleImports {
+		if !imported.Resolved {
+			continue
+		}
+		query := "?"
+		if strings.Contains(imported.Specifier, "?") {
+			query = "&"
+		}
+		query += "astro&type=style"
+		if imported.Scoped {
+			query += "&scope=" + p.opts.Scope
+		}
+		// import './global.css?astro&type=style';
+		p.print(fmt.Sprintf("import %s;", quoteString(imported.Specifier+query)))
+		printed = true
+	}
+	if printed {
+		p.print("\n")
+	}
+	p.hasStyleImports = true
+}
+
 func (p *printer) printReturnOpen() {
 	p.addNilSourceMapping()
 	p.print("return ")
@@ -100,7 +208,7 @@ func (p *printer) printReturnClose() {
 
 func (p *printer) printTemplateLiteralOpen() {
 	p.addNilSourceMapping()
-	p.print(fmt.Sprintf("%s%s", TEMPLATE_TAG, BACKTICK))
+	p.print(fmt.Sprintf("%s%s", p.helper(TEMPLATE_TAG), BACKTICK))
 }
 
 func (p *printer) printTemplateLiteralClose() {
@@ -132,9 +240,13 @@ func (p *printer) printDefineVars(n *astro.Node) {
 				value = strings.TrimSpace(attr.Val)
 			}
 			p.addNilSourceMapping()
-			p.print(fmt.Sprintf("${%s(", defineCall))
-			p.addSourceMapping(attr.ValLoc)
-			p.print(value)
+			p.print(fmt.Sprintf("${%s(", p.helper(defineCall)))
+			if attr.Type == astro.ExpressionAttribute {
+				p.printTrimmedCode(attr.Val, attr.ValLoc.Start)
+			} else {
+				p.addSourceMapping(attr.ValLoc)
+				p.print(value)
+			}
 			p.addNilSourceMapping()
 			p.print(")}")
 			return
@@ -148,8 +260,8 @@ func (p *printer) printFuncPrelude(componentName string) {
 	}
 	p.addNilSourceMapping()
 	p.println("\n//@ts-ignore")
-	p.println(fmt.Sprintf("const %s = %s(async (%s, $$props, %s) => {", componentName, CREATE_COMPONENT, RESULT, SLOTS))
-	p.println(fmt.Sprintf("const Astro = %s.createAstro($$Astro, $$props, %s);", RESULT, SLOTS))
+	p.println(fmt.Sprintf("const %s = %s(async (%s, %s, %s) => {", componentName, p.helper(CREATE_COMPONENT), RESULT, PROPS, SLOTS))
+	p.println(fmt.Sprintf("const Astro = %s.createAstro(%s, %s, %s);", RESULT, ASTRO, PROPS, SLOTS))
 	p.hasFuncPrelude = true
 }
 
@@ -185,17 +297,22 @@ func (p *printer) printAttributesToObject(n *astro.Node) {
 			if a.Val == "" {
 				p.print(`(void 0)`)
 			} else {
-				p.print(`(` + a.Val + `)`)
+				p.print(`(`)
+				p.printExpressionAttribute(a, p.printCode)
+				p.print(`)`)
 			}
 		case astro.SpreadAttribute:
 			p.addSourceMapping(loc.Loc{Start: a.KeyLoc.Start - 3})
-			p.print(`...(` + strings.TrimSpace(a.Key) + `)`)
+			p.print(`...(`)
+			p.printTrimmedCode(a.Key, a.KeyLoc.Start)
+			p.print(`)`)
 		case astro.ShorthandAttribute:
 			p.addSourceMapping(a.KeyLoc)
 			p.print(`"` + strings.TrimSpace(a.Key) + `"`)
 			p.print(":")
-			p.addSourceMapping(a.KeyLoc)
-			p.print(`(` + strings.TrimSpace(a.Key) + `)`)
+			p.print(`(`)
+			p.printTrimmedCode(a.Key, a.KeyLoc.Start)
+			p.print(`)`)
 		case astro.TemplateLiteralAttribute:
 			p.addSourceMapping(a.KeyLoc)
 			p.print(`"` + strings.TrimSpace(a.Key) + `"`)
@@ -209,17 +326,91 @@ func (p *printer) printAttributesToObject(n *astro.Node) {
 func (p *printer) printStyleOrScript(n *astro.Node) {
 	p.addNilSourceMapping()
 	p.print("{props:")
+	if n.DataAtom == atom.Style && transform.IsScopedStyleVars(n) {
+		p.printScopedStyleVars(n)
+		return
+	}
 	p.printAttributesToObject(n)
 	if n.FirstChild != nil && strings.TrimSpace(n.FirstChild.Data) != "" {
 		p.print(",children:`")
-		p.addSourceMapping(n.Loc[0])
-		p.print(escapeText(strings.TrimSpace(n.FirstChild.Data)))
+		p.printMappedText(n.FirstChild, n.Loc[0])
 		p.addNilSourceMapping()
 		p.print("`")
 	}
 	p.print("},\n")
 }
 
+// Print the trimmed and escaped text of a <style> or <script>. Text that a
+// preprocessor rewrote is mapped back through its SourceMappings and input
+// source map, and anything else is mapped to fallback.
+func (p *printer) printMappedText(n *astro.Node, fallback loc.Loc) {
+	text := strings.TrimSpace(n.Data)
+	leading := len(n.Data) - len(strings.TrimLeftFunc(n.Data, unicode.IsSpace))
+	mappings := n.SourceMappings
+	if n.InputSourceMap == nil {
+		mappings = nil
+	}
+	if len(mappings) == 0 || mappings[0].Generated > leading {
+		p.addSourceMapping(fallback)
+	}
+	printed := 0
+	for _, mapping := range mappings {
+		offset := mapping.Generated - leading
+		if offset < printed {
+			offset = printed
+		}
+		if offset >= len(text) {
+			break
+		}
+		chunk := escapeText(text[printed:offset])
+		// A `$` split from the `{` after it must still not start an interpolation
+		if strings.HasSuffix(chunk, "$") && text[offset] == '{' {
+			chunk = chunk[:len(chunk)-1] + "\\$"
+		}
+		p.print(chunk)
+		p.builder.AddInputSourceMapping(n.InputSourceMap, mapping.Original, p.output)
+		printed = offset
+	}
+	p.print(escapeText(text[printed:]))
+}
+
+// Print a <style define:vars> whose variables only apply to the current
+// component instance. Rather than passing `define:vars` to the runtime,
+// which would define them on `:root`, the rule is generated inline.
+func (p *printer) printScopedStyleVars(n *astro.Node) {
+	style := *n
+	style.Attr = make([]astro.Attribute, 0, len(n.Attr))
+	var vars astro.Attribute
+	for _, attr := range n.Attr {
+		if attr.Key == "define:vars" {
+			vars = attr
+			continue
+		}
+		style.Attr = append(style.Attr, attr)
+	}
+	p.printAttributesToObject(&style)
+	p.print(",children:`")
+	p.print(fmt.Sprintf(`[%s="${%s}"]{${Object.entries(`, transform.StyleVarsAttribute, transform.StyleVarsID))
+	p.addSourceMapping(vars.ValLoc)
+	switch vars.Type {
+	case astro.ExpressionAttribute:
+		p.print(strings.TrimSpace(vars.Val))
+	default:
+		// Transform reports any other kind of value
+		p.print("{}")
+	}
+	p.addNilSourceMapping()
+	// Escape whatever could end the declaration, the rule or the <style>
+	p.print(").map(([key, value]) => `--${key}:${value}`.replace(/[<>{};\\\\]/g, (c) => `\\\\${c.charCodeAt(0).toString(16)} `) + ';').join('')}}")
+	// With StaticExtraction the stylesheet itself is in the extracted CSS
+	if !p.opts.StaticExtraction && n.FirstChild != nil && strings.TrimSpace(n.FirstChild.Data) != "" {
+		p.addSourceMapping(n.Loc[0])
+		p.print(escapeText(strings.TrimSpace(n.FirstChild.Data)))
+		p.addNilSourceMapping()
+	}
+	p.print("`},\n")
+}
+
 func (p *printer) printAttribute(attr astro.Attribute) {
 	if attr.Key == "define:vars" {
 		return
@@ -245,28 +436,26 @@ func (p *printer) printAttribute(attr astro.Attribute) {
 		p.addSourceMapping(attr.KeyLoc)
 		p.print(attr.Key)
 	case astro.ExpressionAttribute:
-		p.print(fmt.Sprintf("${%s(", ADD_ATTRIBUTE))
-		p.addSourceMapping(attr.ValLoc)
+		p.print(fmt.Sprintf("${%s(", p.helper(ADD_ATTRIBUTE)))
 		if strings.TrimSpace(attr.Val) == "" {
+			p.addSourceMapping(attr.ValLoc)
 			p.print("(void 0)")
 		} else {
-			p.print(strings.TrimSpace(attr.Val))
+			p.printExpressionAttribute(attr, p.printTrimmedCode)
 		}
 		p.addSourceMapping(attr.KeyLoc)
 		p.print(`, "` + strings.TrimSpace(attr.Key) + `")}`)
 	case astro.SpreadAttribute:
-		p.print(fmt.Sprintf("${%s(", SPREAD_ATTRIBUTES))
-		p.addSourceMapping(loc.Loc{Start: attr.KeyLoc.Start - 3})
-		p.print(strings.TrimSpace(attr.Key))
+		p.print(fmt.Sprintf("${%s(", p.helper(SPREAD_ATTRIBUTES)))
+		p.printTrimmedCode(attr.Key, attr.KeyLoc.Start)
 		p.print(`, "` + strings.TrimSpace(attr.Key) + `")}`)
 	case astro.ShorthandAttribute:
-		p.print(fmt.Sprintf("${%s(", ADD_ATTRIBUTE))
-		p.addSourceMapping(attr.KeyLoc)
-		p.print(strings.TrimSpace(attr.Key))
+		p.print(fmt.Sprintf("${%s(", p.helper(ADD_ATTRIBUTE)))
+		p.printTrimmedCode(attr.Key, attr.KeyLoc.Start)
 		p.addSourceMapping(attr.KeyLoc)
 		p.print(`, "` + strings.TrimSpace(attr.Key) + `")}`)
 	case astro.TemplateLiteralAttribute:
-		p.print(fmt.Sprintf("${%s(`", ADD_ATTRIBUTE))
+		p.print(fmt.Sprintf("${%s(`", p.helper(ADD_ATTRIBUTE)))
 		p.addSourceMapping(attr.ValLoc)
 		p.print(strings.TrimSpace(attr.Val))
 		p.addSourceMapping(attr.KeyLoc)
@@ -278,24 +467,140 @@ func (p *printer) addSourceMapping(location loc.Loc) {
 	p.builder.AddSourceMapping(location, p.output)
 }
 
+func (p *printer) addNamedSourceMapping(location loc.Loc, name string) {
+	p.builder.AddNamedSourceMapping(location, name, p.output)
+}
+
+// Print code that was copied from start in the source, with a mapping at
+// every token, so stack traces point at the right column. Identifiers keep
+// their name in the source map. Like addNilSourceMapping, a start of 0 means
+// the code was generated and isn't in the source at all.
+func (p *printer) printCode(code string, start int) {
+	if start == 0 {
+		p.addNilSourceMapping()
+		p.print(code)
+		return
+	}
+	tokens := js_scanner.Tokens([]byte(code))
+	if len(tokens) == 0 || tokens[0].Start > 0 {
+		p.addSourceMapping(loc.Loc{Start: start})
+	}
+	printed := 0
+	for _, token := range tokens {
+		p.print(code[printed:token.Start])
+		if token.IsIdentifier {
+			p.addNamedSourceMapping(loc.Loc{Start: start + token.Start}, code[token.Start:token.End])
+		} else {
+			p.addSourceMapping(loc.Loc{Start: start + token.Start})
+		}
+		printed = token.Start
+	}
+	p.print(code[printed:])
+}
+
+// Print the value of an expression attribute. When transform has wrapped
+// the expression, like scoped `class` expressions, only the expression
+// inside is copied from the source.
+func (p *printer) printExpressionAttribute(attr astro.Attribute, printCode func(code string, start int)) {
+	expr, ok := transform.SourceValue(attr)
+	if !ok {
+		printCode(attr.Val, attr.ValLoc.Start)
+		return
+	}
+	p.print(attr.Val[:attr.ValSource.Start])
+	printCode(expr, attr.ValLoc.Start)
+	p.addNilSourceMapping()
+	p.print(attr.Val[attr.ValSource.End:])
+}
+
+// Print code that was copied from start in the source without the
+// whitespace around it
+func (p *printer) printTrimmedCode(code string, start int) {
+	leading := len(code) - len(strings.TrimLeftFunc(code, unicode.IsSpace))
+	if start != 0 {
+		start += leading
+	}
+	p.printCode(strings.TrimSpace(code), start)
+}
+
+// Print the parts of code in spans as if they were joined and trimmed, with
+// code copied from start in the source
+func (p *printer) printCodeSpans(code string, spans []loc.Span, start int) {
+	for len(spans) > 0 && strings.TrimSpace(code[spans[0].Start:spans[0].End]) == "" {
+		spans = spans[1:]
+	}
+	for len(spans) > 0 && strings.TrimSpace(code[spans[len(spans)-1].Start:spans[len(spans)-1].End]) == "" {
+		spans = spans[:len(spans)-1]
+	}
+	for i, span := range spans {
+		text := code[span.Start:span.End]
+		if i == 0 {
+			trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+			span.Start += len(text) - len(trimmed)
+			text = trimmed
+		}
+		if i == len(spans)-1 {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+		p.printCode(text, start+span.Start)
+	}
+}
+
 func (p *printer) addNilSourceMapping() {
 	p.builder.AddSourceMapping(loc.Loc{Start: 0}, p.output)
 }
 
 func (p *printer) printTopLevelAstro() {
-	p.println(fmt.Sprintf("const $$Astro = %s(import.meta.url, '%s', '%s');\nconst Astro = $$Astro;", CREATE_ASTRO, p.opts.Site, p.opts.ProjectRoot))
+	p.println(fmt.Sprintf("const %s = %s(import.meta.url, '%s', '%s');\nconst Astro = %s;", ASTRO, p.helper(CREATE_ASTRO), p.opts.Site, p.opts.ProjectRoot, ASTRO))
+	if p.hasScopedStyleVars {
+		p.println(fmt.Sprintf("const %s = new WeakMap();", STYLE_VARS_COUNT))
+	}
+}
+
+// Every instance of the component gets its own id, so that scoped
+// `define:vars` don't conflict between instances. Ids are counted per
+// render, keyed by `$$result`, so the counter doesn't outlive the request.
+func (p *printer) printStyleVarsID() {
+	if !p.hasScopedStyleVars {
+		return
+	}
+	p.println(fmt.Sprintf("const %s = `%s-${%s.set(%s, (%s.get(%s) || 0) + 1).get(%s)}`;", transform.StyleVarsID, p.opts.Scope, STYLE_VARS_COUNT, RESULT, STYLE_VARS_COUNT, RESULT, RESULT))
 }
 
 func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.TransformOptions, source []byte) {
+	var modules []string
 	var specs []string
 	var asrts []string
+	var dynamicImports []string
 
 	modCount := 1
-	loc, statement := js_scanner.NextImportStatement(source, 0)
-	for loc != -1 {
+	for _, statement := range js_scanner.FindImports(source) {
+		// Type-only imports are erased, so there's no module to import at runtime
+		if statement.IsTypeOnly() {
+			continue
+		}
+		// Dynamic imports stay lazy, so they're listed separately from the
+		// namespaces in `modules` and only loaded when they're needed
+		if statement.Kind == js_scanner.ImportDynamic {
+			asrt := "{}"
+			load := fmt.Sprintf("import(%s)", quoteString(statement.Specifier))
+			if statement.Assertions != "" {
+				asrt = statement.Assertions
+				load = fmt.Sprintf("import(%s, { assert: %s })", quoteString(statement.Specifier), asrt)
+			}
+			dynamicImports = append(dynamicImports, fmt.Sprintf("{ module: () => %s, specifier: %s, assert: %s }", load, quoteString(statement.Specifier), asrt))
+			continue
+		}
 		isClientOnlyImport := false
 		for _, n := range doc.ClientOnlyComponents {
+			// Re-exports don't bind any names in the component
+			if statement.Kind != js_scanner.ImportDeclaration {
+				break
+			}
 			for _, imported := range statement.Imports {
+				if imported.IsType {
+					continue
+				}
 				if imported.ExportName == "*" {
 					prefix := fmt.Sprintf("%s.", imported.LocalName)
 
@@ -305,7 +610,7 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 						// Inject metadata attributes to `client:only` Component
 						pathAttr := astro.Attribute{
 							Key:  "client:component-path",
-							Val:  fmt.Sprintf(`$$metadata.resolvePath("%s")`, statement.Specifier),
+							Val:  fmt.Sprintf(`%s.resolvePath(%s)`, METADATA, quoteString(statement.Specifier)),
 							Type: astro.ExpressionAttribute,
 						}
 						n.Attr = append(n.Attr, pathAttr)
@@ -324,7 +629,7 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 					// Inject metadata attributes to `client:only` Component
 					pathAttr := astro.Attribute{
 						Key:  "client:component-path",
-						Val:  fmt.Sprintf(`$$metadata.resolvePath("%s")`, statement.Specifier),
+						Val:  fmt.Sprintf(`%s.resolvePath(%s)`, METADATA, quoteString(statement.Specifier)),
 						Type: astro.ExpressionAttribute,
 					}
 					n.Attr = append(n.Attr, pathAttr)
@@ -350,12 +655,12 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 				assertions += " assert "
 				assertions += statement.Assertions
 			}
-			p.print(fmt.Sprintf("\nimport * as $$module%v from '%s'%s;", modCount, statement.Specifier, assertions))
+			p.print(fmt.Sprintf("\nimport * as %s%v from %s%s;", MODULE, modCount, quoteString(statement.Specifier), assertions))
+			modules = append(modules, fmt.Sprintf("%s%v", MODULE, modCount))
 			specs = append(specs, statement.Specifier)
 			asrts = append(asrts, statement.Assertions)
 			modCount++
 		}
-		loc, statement = js_scanner.NextImportStatement(source, loc)
 	}
 	// If we added imports, add a line break.
 	if modCount > 1 {
@@ -369,22 +674,27 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 	} else {
 		patharg = fmt.Sprintf("\"%s\"", patharg)
 	}
-	p.print(fmt.Sprintf("\nexport const $$metadata = %s(%s, { ", CREATE_METADATA, patharg))
+	p.print(fmt.Sprintf("\nexport const %s = %s(%s, { ", METADATA, p.helper(CREATE_METADATA), patharg))
 
 	// Add modules
 	p.print("modules: [")
-	for i := 1; i < modCount; i++ {
-		if i > 1 {
+	for i, module := range modules {
+		if i > 0 {
 			p.print(", ")
 		}
 		asrt := "{}"
-		if asrts[i-1] != "" {
-			asrt = asrts[i-1]
+		if asrts[i] != "" {
+			asrt = asrts[i]
 		}
-		p.print(fmt.Sprintf("{ module: $$module%v, specifier: '%s', assert: %s }", i, specs[i-1], asrt))
+		p.print(fmt.Sprintf("{ module: %s, specifier: %s, assert: %s }", module, quoteString(specs[i]), asrt))
 	}
 	p.print("]")
 
+	// Only components with dynamic imports have this field
+	if len(dynamicImports) > 0 {
+		p.print(fmt.Sprintf(", dynamicImports: [%s]", strings.Join(dynamicImports, ", ")))
+	}
+
 	// Hydrated Components
 	p.print(", hydratedComponents: [")
 	for i, node := range doc.HydratedComponents {
@@ -408,7 +718,7 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 		i++
 	}
 	p.print("]), hoisted: [")
-	for i, node := range doc.Scripts {
+	for i, node := range hoistedScripts(doc) {
 		if i > 0 {
 			p.print(", ")
 		}
@@ -416,7 +726,10 @@ func (p *printer) printComponentMetadata(doc *astro.Node, opts transform.Transfo
 		src := astro.GetAttribute(node, "src")
 		if src != nil {
 			p.print(fmt.Sprintf("{ type: 'remote', src: '%s' }", escapeSingleQuote(src.Val)))
-		} else if node.FirstChild != nil {
+		} else if opts.StaticScriptExtraction {
+			// The script is imported at the top of the module instead
+			p.print(fmt.Sprintf("{ type: 'external', src: '%s' }", escapeSingleQuote(ScriptModuleID(opts.Filename, i, scriptLang(node)))))
+		} else {
 			p.print(fmt.Sprintf("{ type: 'inline', value: `%s` }", escapeInterpolation(escapeBackticks(node.FirstChild.Data))))
 		}
 	}
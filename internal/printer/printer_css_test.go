@@ -80,3 +80,36 @@ func TestPrinterCSS(t *testing.T) {
 		})
 	}
 }
+
+func TestStaticExtractionStyleVars(t *testing.T) {
+	source := `---
+const color = "red";
+---
+<style define:vars={{ color }}>h1 { color: var(--color); }</style>
+<style>p { margin: 0; }</style>
+<h1>Hello</h1>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := transform.TransformOptions{Scope: "XXXX", StaticExtraction: true}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, opts)
+
+	css := ""
+	for _, bytes := range PrintCSS(source, doc, opts).Output {
+		css += string(bytes)
+	}
+	if want := "h1.astro-XXXX{color:var(--color);}"; !strings.Contains(css, want) {
+		t.Errorf("expected %q in the extracted CSS:\n%s", want, css)
+	}
+
+	output := string(PrintToJS(source, doc, 0, opts).Output)
+	want := "const STYLES = [\n{props:{\"data-astro-id\":\"XXXX\"},children:`[data-astro-vars=\"${$$styleVarsId}\"]{${Object.entries({ color }).map(([key, value]) => `--${key}:${value}`.replace(/[<>{};\\\\]/g, (c) => `\\\\${c.charCodeAt(0).toString(16)} `) + ';').join('')}}`},\n];"
+	if !strings.Contains(output, want) {
+		t.Errorf("expected only the define:vars rule at runtime:\n%s", output)
+	}
+	if !strings.Contains(output, `<h1 class="astro-XXXX"${$$addAttribute($$styleVarsId, "data-astro-vars")}>`) {
+		t.Errorf("expected data-astro-vars on the element:\n%s", output)
+	}
+}
@@ -0,0 +1,133 @@
+package printer
+
+import (
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/transform"
+)
+
+func TestPrintHoistedScripts(t *testing.T) {
+	source := `<div />
+<script hoist src="https://example.com/a.js"></script>
+<script hoist>
+  const greeting = 'Hello';
+  console.log(greeting);
+</script>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, transform.TransformOptions{})
+	scripts := PrintHoistedScripts(source, doc)
+	if len(scripts) != 2 {
+		t.Fatalf("expected 2 scripts, got %d", len(scripts))
+	}
+
+	// Scripts are listed in the same order as $$metadata.hoisted
+	remote, inline := scripts[1], scripts[0]
+	if remote.Type != "remote" || remote.Src != "https://example.com/a.js" || len(remote.Code) != 0 {
+		t.Errorf("unexpected remote script %+v", remote)
+	}
+	if inline.Type != "inline" || !strings.Contains(string(inline.Code), "console.log(greeting);") {
+		t.Fatalf("unexpected inline script %+v", inline)
+	}
+
+	names := inline.SourceMapChunk.Names
+	for _, name := range []string{"greeting =", "console", "log", "greeting)"} {
+		mapping := assertMapsTo(t, string(inline.Code), inline.SourceMapChunk, source, name, name)
+		if mapping == nil {
+			continue
+		}
+		if identifier := strings.TrimRight(name, " =)"); mapping.NameIndex == -1 || names[mapping.NameIndex] != identifier {
+			t.Errorf("expected the name %q for %q", identifier, name)
+		}
+	}
+}
+
+func TestStaticScriptExtraction(t *testing.T) {
+	source := `---
+const a = 1;
+---
+<div />
+<script hoist lang="ts">const b: number = 2;</script>
+<script hoist src="/c.js"></script>
+<script hoist>console.log(` + "`${a}`" + `);</script>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := transform.TransformOptions{Filename: "/src/pages/index.astro", StaticScriptExtraction: true}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, opts)
+	output := string(PrintToJS(source, doc, 0, opts).Output)
+
+	scripts := PrintHoistedScripts(source, doc)
+	want := []string{
+		`import "/src/pages/index.astro?astro&type=script&index=0&lang.js";`,
+		`import "/src/pages/index.astro?astro&type=script&index=2&lang.ts";`,
+		`hoisted: [{ type: 'external', src: '/src/pages/index.astro?astro&type=script&index=0&lang.js' }, { type: 'remote', src: '/c.js' }, { type: 'external', src: '/src/pages/index.astro?astro&type=script&index=2&lang.ts' }]`,
+	}
+	for _, w := range want {
+		if !strings.Contains(output, w) {
+			t.Errorf("expected the output to contain %s\n%s", w, output)
+		}
+	}
+	if strings.Contains(output, "console.log") || strings.Contains(output, "const b") {
+		t.Errorf("scripts should not be inlined:\n%s", output)
+	}
+	if !strings.Contains(output, `{props:{"hoist":true,"src":"/c.js"}}`) {
+		t.Errorf("remote scripts should still be rendered:\n%s", output)
+	}
+
+	// The index of each import matches the script in the transform result
+	if len(scripts) != 3 || scripts[0].Lang != "js" || scripts[2].Lang != "ts" || string(scripts[2].Code) != "const b: number = 2;" {
+		t.Errorf("unexpected scripts %+v", scripts)
+	}
+}
+
+func TestStaticScriptExtractionImports(t *testing.T) {
+	compile := func(source string, opts transform.TransformOptions) string {
+		doc, err := astro.Parse(strings.NewReader(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		transform.ExtractStyles(doc)
+		transform.Transform(doc, opts)
+		return string(PrintToJS(source, doc, 0, opts).Output)
+	}
+	opts := transform.TransformOptions{Filename: "/src/pages/index.astro", StaticScriptExtraction: true}
+
+	// Without inline scripts there's nothing to import, so the output doesn't change
+	remote := `<div /><script hoist src="/c.js"></script>`
+	if got, want := compile(remote, opts), compile(remote, transform.TransformOptions{Filename: opts.Filename}); got != want {
+		t.Errorf("expected the same output as without extraction\n%s", got)
+	}
+
+	quoted := `<div /><script hoist lang='t"s'>a()</script>`
+	if output := compile(quoted, opts); !strings.Contains(output, `import "/src/pages/index.astro?astro&type=script&index=0&lang.t\"s";`) {
+		t.Errorf("expected the lang to be escaped\n%s", output)
+	}
+}
+
+func TestPrintHoistedScriptsMatchesMetadata(t *testing.T) {
+	source := `<div /><script hoist></script><script hoist>a()</script>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, transform.TransformOptions{})
+	output := string(PrintToJS(source, doc, 0, transform.TransformOptions{}).Output)
+
+	// Empty scripts are left out of both, so the indexes agree
+	scripts := PrintHoistedScripts(source, doc)
+	if len(scripts) != 1 || string(scripts[0].Code) != "a()" {
+		t.Errorf("unexpected scripts %+v", scripts)
+	}
+	if !strings.Contains(output, "hoisted: [{ type: 'inline', value: `a()` }]") {
+		t.Errorf("unexpected $$metadata.hoisted\n%s", output)
+	}
+}
@@ -7,25 +7,64 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"unicode/utf16"
 
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/sourcemap"
 	"github.com/withastro/compiler/internal/test_utils"
 	"github.com/withastro/compiler/internal/transform"
 )
 
-var INTERNAL_IMPORTS = fmt.Sprintf("import {\n  %s\n} from \"%s\";\n", strings.Join([]string{
-	FRAGMENT,
-	"render as " + TEMPLATE_TAG,
-	"createAstro as " + CREATE_ASTRO,
-	"createComponent as " + CREATE_COMPONENT,
-	"renderComponent as " + RENDER_COMPONENT,
-	"renderSlot as " + RENDER_SLOT,
-	"addAttribute as " + ADD_ATTRIBUTE,
-	"spreadAttributes as " + SPREAD_ATTRIBUTES,
-	"defineStyleVars as " + DEFINE_STYLE_VARS,
-	"defineScriptVars as " + DEFINE_SCRIPT_VARS,
-	"createMetadata as " + CREATE_METADATA,
-}, ",\n  "), "http://localhost:3000/")
+// Checks that a mapping starts at the first generated text in output, and
+// that it points at the first original text in source. The mapping is
+// returned for more checks, or nil if there isn't one.
+func assertMapsTo(t *testing.T, output string, chunk sourcemap.Chunk, source string, generated string, original string) *sourcemap.Mapping {
+	t.Helper()
+	mappings, err := sourcemap.DecodeMappings(string(chunk.Buffer), 1+len(chunk.Sources), len(chunk.Names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &sourcemap.SourceMap{Sources: append([]string{"a.astro"}, chunk.Sources...), Names: chunk.Names, Mappings: mappings}
+	offset := strings.Index(output, generated)
+	if offset == -1 {
+		t.Errorf("%q is missing from the output:\n%s", generated, output)
+		return nil
+	}
+	line := strings.Count(output[:offset], "\n")
+	column := offset - strings.LastIndex(output[:offset], "\n") - 1
+	mapping := sm.Find(line, column)
+	if mapping == nil || mapping.GeneratedColumn != column {
+		t.Errorf("no mapping at the start of %q", generated)
+		return nil
+	}
+	if mapping.SourceIndex != 0 {
+		t.Errorf("%q maps to %s, expected the component", generated, sm.Sources[mapping.SourceIndex])
+		return nil
+	}
+	start, _ := sourcemap.ByteOffset(source, mapping.OriginalLine, mapping.OriginalColumn)
+	if start != strings.Index(source, original) {
+		t.Errorf("%q maps to %q, expected %q", generated, source[start:], original)
+	}
+	return mapping
+}
+
+// The imports of the helpers that code uses, as the printer should print them
+func internalImports(code string) string {
+	specifiers := []string{}
+	for _, h := range internalHelpers {
+		// `$` isn't a word character, so `\b` can't mark where `$$` names start
+		if !regexp.MustCompile(`(^|[^\w$])` + regexp.QuoteMeta(h.local) + `\b`).MatchString(code) {
+			continue
+		}
+		if h.local == h.exported {
+			specifiers = append(specifiers, h.local)
+		} else {
+			specifiers = append(specifiers, h.exported+" as "+h.local)
+		}
+	}
+	return fmt.Sprintf("import {\n  %s\n} from \"%s\";\n", strings.Join(specifiers, ",\n  "), "http://localhost:3000/")
+}
+
 var PRELUDE = fmt.Sprintf(`//@ts-ignore
 const $$Component = %s(async ($$result, $$props, %s) => {
 const Astro = $$result.createAstro($$Astro, $$props, %s);%s`, CREATE_COMPONENT, SLOTS, SLOTS, "\n")
@@ -38,6 +77,8 @@ var STYLE_SUFFIX = "];\nfor (const STYLE of STYLES) $$result.styles.add(STYLE);\
 var SCRIPT_PRELUDE = "const SCRIPTS = [\n"
 var SCRIPT_SUFFIX = "];\nfor (const SCRIPT of SCRIPTS) $$result.scripts.add(SCRIPT);\n"
 var CREATE_ASTRO_CALL = "const $$Astro = $$createAstro(import.meta.url, 'https://astro.build', '.');\nconst Astro = $$Astro;"
+var STYLE_VARS_COUNTER = "const $$styleVarsCount = new WeakMap();"
+var STYLE_VARS_INSTANCE = "const $$styleVarsId = `astro-XXXX-${$$styleVarsCount.set($$result, ($$styleVarsCount.get($$result) || 0) + 1).get($$result)}`;\n"
 
 // SPECIAL TEST FIXTURES
 var NON_WHITESPACE_CHARS = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#$%^&*()-_=+[];:'\",.?")
@@ -49,6 +90,7 @@ type want struct {
 	getStaticPaths string
 	code           string
 	skipHoist      bool // HACK: sometimes `getStaticPaths()` appears in a slightly-different location. Only use this if needed!
+	styleVars      bool
 	metadata
 }
 
@@ -56,6 +98,7 @@ type metadata struct {
 	hoisted             []string
 	hydratedComponents  []string
 	modules             []string
+	dynamicImports      []string
 	hydrationDirectives []string
 }
 
@@ -142,6 +185,53 @@ const b = 0;`},
 				code: `<html><head></head><body><div></div></body></html>`,
 			},
 		},
+		{
+			name: "exports (hoisted)",
+			source: `---
+const a = 0;
+export const prerender = true;
+export function format(value: number) {
+	return value.toFixed(2)
+}
+const b = 0;
+---
+<div></div>`,
+			want: want{
+				frontmatter: []string{"", `const a = 0;
+const b = 0;`},
+				getStaticPaths: `export const prerender = true;
+export function format(value: number) {
+	return value.toFixed(2)
+}`,
+				code: `<html><head></head><body><div></div></body></html>`,
+			},
+		},
+		{
+			name: "type before import",
+			source: `---
+type P = { a: string }
+import b from 'b'
+const c = b
+---
+<div>{c}</div>`,
+			want: want{
+				frontmatter: []string{"type P = { a: string }\nimport b from 'b'", `const c = b`},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "b", assert: {} }`}},
+				code:        `<html><head></head><body><div>${c}</div></body></html>`,
+			},
+		},
+		{
+			name: "exports (const assertion)",
+			source: `---
+export const SIZES = ['sm', 'lg'] as const
+const { size } = Astro.props
+---
+<div>{size}</div>`,
+			want: want{
+				frontmatter: []string{`export const SIZES = ['sm', 'lg'] as const`, `const { size } = Astro.props`},
+				code:        `<html><head></head><body><div>${size}</div></body></html>`,
+			},
+		},
 		{
 			name: "import assertions",
 			source: `---
@@ -152,7 +242,7 @@ import data from "test" assert { type: 'json' };
 				frontmatter: []string{
 					`import data from "test" assert { type: 'json' };`,
 				},
-				metadata: metadata{modules: []string{`{ module: $$module1, specifier: 'test', assert: {type:'json'} }`}},
+				metadata: metadata{modules: []string{`{ module: $$module1, specifier: "test", assert: {type:'json'} }`}},
 				styles:   []string{},
 				code:     `<html><head></head><body></body></html>`,
 			},
@@ -174,7 +264,7 @@ import VueComponent from '../components/Vue.vue';
 				frontmatter: []string{
 					`import VueComponent from '../components/Vue.vue';`,
 				},
-				metadata: metadata{modules: []string{`{ module: $$module1, specifier: '../components/Vue.vue', assert: {} }`}},
+				metadata: metadata{modules: []string{`{ module: $$module1, specifier: "../components/Vue.vue", assert: {} }`}},
 				code: `<html>
   <head>
     <title>Hello world</title>
@@ -200,7 +290,7 @@ import * as ns from '../components';
 			want: want{
 				frontmatter: []string{`import * as ns from '../components';`},
 				styles:      []string{},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: '../components', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "../components", assert: {} }`}},
 				code: `<html>
   <head>
     <title>Hello world</title>
@@ -469,7 +559,7 @@ import Component from "test";
 </Component>`,
 			want: want{
 				frontmatter: []string{`import Component from "test";`},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: 'test', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "test", assert: {} }`}},
 				code:        `${$$renderComponent($$result,'Component',Component,{},{"default": () => $$render` + "`" + `<div>Default</div>` + "`" + `,"named": () => $$render` + "`" + `<div>Named</div>` + "`" + `,})}`,
 			},
 		},
@@ -485,7 +575,7 @@ import Component from 'test';
 </Component>`,
 			want: want{
 				frontmatter: []string{`import Component from 'test';`},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: 'test', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "test", assert: {} }`}},
 				code:        `${$$renderComponent($$result,'Component',Component,{},{"default": () => $$render` + "`" + `<div>Default</div>` + "`" + `,"named": () => $$render` + "`" + `<div>Named</div>` + "`" + `,})}`,
 			},
 		},
@@ -657,7 +747,7 @@ import Counter from '../components/Counter.jsx'`,
 // https://docs.astro.build/core-concepts/astro-components/`},
 				styles: []string{fmt.Sprintf(`{props:{"data-astro-id":"HMNNHVCQ"},children:%s:root{font-family:system-ui;padding:2em 0;}.counter{display:grid;grid-template-columns:repeat(3,minmax(0,1fr));place-items:center;font-size:2em;margin-top:2em;}.children{display:grid;place-items:center;margin-bottom:2em;}%s}`, BACKTICK, BACKTICK)},
 				metadata: metadata{
-					modules:             []string{`{ module: $$module1, specifier: '../components/Counter.jsx', assert: {} }`},
+					modules:             []string{`{ module: $$module1, specifier: "../components/Counter.jsx", assert: {} }`},
 					hydratedComponents:  []string{`Counter`},
 					hydrationDirectives: []string{"visible"},
 				},
@@ -691,8 +781,8 @@ import Widget2 from '../components/Widget2.astro';`},
 				styles: []string{},
 				metadata: metadata{
 					modules: []string{
-						`{ module: $$module1, specifier: '../components/Widget.astro', assert: {} }`,
-						`{ module: $$module2, specifier: '../components/Widget2.astro', assert: {} }`},
+						`{ module: $$module1, specifier: "../components/Widget.astro", assert: {} }`,
+						`{ module: $$module2, specifier: "../components/Widget2.astro", assert: {} }`},
 				},
 				code: `<html lang="en">
   <head>
@@ -781,7 +871,7 @@ import Widget2 from '../components/Widget2.astro';`},
 			want: want{
 				frontmatter: []string{`import Component from 'test';`, `const name = 'named';`},
 				styles:      []string{},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: 'test', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "test", assert: {} }`}},
 				code:        `${$$renderComponent($$result,'Component',Component,{},{[name]: () => $$render` + "`" + `<div>Named</div>` + "`" + `,})}`,
 			},
 		},
@@ -808,7 +898,7 @@ import 'test';
 			want: want{
 				frontmatter: []string{`import 'test';`},
 				styles:      []string{},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: 'test', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "test", assert: {} }`}},
 				code:        `<html><head></head><body>${$$renderComponent($$result,'my-element','my-element',{})}</body></html>`,
 			},
 		},
@@ -831,9 +921,9 @@ import 'custom-element';`,
 					`const name = 'world';`},
 				metadata: metadata{
 					modules: []string{
-						`{ module: $$module1, specifier: 'one', assert: {} }`,
-						`{ module: $$module2, specifier: 'two', assert: {} }`,
-						`{ module: $$module3, specifier: 'custom-element', assert: {} }`,
+						`{ module: $$module1, specifier: "one", assert: {} }`,
+						`{ module: $$module2, specifier: "two", assert: {} }`,
+						`{ module: $$module3, specifier: "custom-element", assert: {} }`,
 					},
 					hydratedComponents:  []string{"'my-element'", "Two", "One"},
 					hydrationDirectives: []string{"load"},
@@ -897,12 +987,11 @@ let allPosts = Astro.fetchContent<MarkdownFrontmatter>('./post/*.md');
 ---
 <div>testing</div>`,
 			want: want{
-				frontmatter: []string{"", `interface MarkdownFrontmatter {
+				frontmatter: []string{`interface MarkdownFrontmatter {
 	date: number;
 	image: string;
 	author: string;
-}
-let allPosts = Astro.fetchContent<MarkdownFrontmatter>('./post/*.md');`},
+}`, `let allPosts = Astro.fetchContent<MarkdownFrontmatter>('./post/*.md');`},
 				styles: []string{},
 				code:   "<html><head></head><body><div>testing</div></body></html>",
 			},
@@ -924,8 +1013,8 @@ import ZComponent from '../components/ZComponent.jsx';
 import ZComponent from '../components/ZComponent.jsx';`},
 				metadata: metadata{
 					modules: []string{
-						`{ module: $$module1, specifier: '../components/AComponent.jsx', assert: {} }`,
-						`{ module: $$module2, specifier: '../components/ZComponent.jsx', assert: {} }`,
+						`{ module: $$module1, specifier: "../components/AComponent.jsx", assert: {} }`,
+						`{ module: $$module2, specifier: "../components/ZComponent.jsx", assert: {} }`,
 					},
 				},
 				code: `<html><head></head><body>
@@ -934,6 +1023,79 @@ import ZComponent from '../components/ZComponent.jsx';`},
 </body></html>`,
 			},
 		},
+		{
+			name: "type-only imports",
+			source: `---
+import type { Props } from '../types';
+import { type Size, Button } from '../components/Button.jsx';
+import { type Theme } from '../theme';
+---
+
+<Button />`,
+			want: want{
+				frontmatter: []string{
+					`import type { Props } from '../types';
+import { type Size, Button } from '../components/Button.jsx';
+import { type Theme } from '../theme';`},
+				metadata: metadata{
+					modules: []string{
+						`{ module: $$module1, specifier: "../components/Button.jsx", assert: {} }`,
+					},
+				},
+				code: `${` + RENDER_COMPONENT + `($$result,'Button',Button,{})}`,
+			},
+		},
+		{
+			name: "dynamic imports and re-exports",
+			source: `---
+import Button from '../components/Button.astro';
+export { default as Card } from '../components/Card.astro';
+const { data } = await import('../data.json');
+---
+
+<Button />`,
+			want: want{
+				frontmatter: []string{
+					`import Button from '../components/Button.astro';
+export { default as Card } from '../components/Card.astro';`,
+					`const { data } = await import('../data.json');`,
+				},
+				metadata: metadata{
+					modules: []string{
+						`{ module: $$module1, specifier: "../components/Button.astro", assert: {} }`,
+						`{ module: $$module2, specifier: "../components/Card.astro", assert: {} }`,
+					},
+					dynamicImports: []string{
+						`{ module: () => import("../data.json"), specifier: "../data.json", assert: {} }`,
+					},
+				},
+				code: `${` + RENDER_COMPONENT + `($$result,'Button',Button,{})}`,
+			},
+		},
+		{
+			name: "quotes in specifiers",
+			source: `---
+import Button from "../it's/Button.astro";
+const { data } = await import("../it's/data.json");
+---
+
+<Button />`,
+			want: want{
+				frontmatter: []string{
+					`import Button from "../it's/Button.astro";`,
+					`const { data } = await import("../it's/data.json");`,
+				},
+				metadata: metadata{
+					modules: []string{
+						`{ module: $$module1, specifier: "../it's/Button.astro", assert: {} }`,
+					},
+					dynamicImports: []string{
+						`{ module: () => import("../it's/data.json"), specifier: "../it's/data.json", assert: {} }`,
+					},
+				},
+				code: `${` + RENDER_COMPONENT + `($$result,'Button',Button,{})}`,
+			},
+		},
 		{
 			name: "Parser can handle files > 4096 chars",
 			source: `<html><body>` + longRandomString + `<img
@@ -989,8 +1151,34 @@ const title = 'icon';
 			name:   "Empty style",
 			source: `<style define:vars={{ color: "Gainsboro" }}></style>`,
 			want: want{
-				styles: []string{`{props:{"define:vars":({ color: "Gainsboro" }),"data-astro-id":"7HAAVZPE"}}`},
-				code:   `<html class="astro-7HAAVZPE"><head></head><body></body></html>`,
+				styles:    []string{"{props:{\"data-astro-id\":\"7HAAVZPE\"},children:`[data-astro-vars=\"${$$styleVarsId}\"]{${Object.entries({ color: \"Gainsboro\" }).map(([key, value]) => `--${key}:${value}`.replace(/[<>{};\\\\]/g, (c) => `\\\\${c.charCodeAt(0).toString(16)} `) + ';').join('')}}`}"},
+				code:      `<html class="astro-7HAAVZPE"><head></head><body></body></html>`,
+				styleVars: true,
+			},
+		},
+		{
+			name: "define:vars scoped per instance",
+			source: `---
+const { color } = Astro.props;
+---
+<style define:vars={{ color }}>h1 { color: var(--color); }</style>
+<h1>Hello</h1>
+<Component><p>World</p></Component>`,
+			want: want{
+				frontmatter: []string{"", "const { color } = Astro.props;"},
+				styles:      []string{"{props:{\"data-astro-id\":\"WDOQQKJK\"},children:`[data-astro-vars=\"${$$styleVarsId}\"]{${Object.entries({ color }).map(([key, value]) => `--${key}:${value}`.replace(/[<>{};\\\\]/g, (c) => `\\\\${c.charCodeAt(0).toString(16)} `) + ';').join('')}}h1.astro-WDOQQKJK{color:var(--color);}`}"},
+				code: `<html class="astro-WDOQQKJK"><head>
+</head><body><h1 class="astro-WDOQQKJK"${$$addAttribute($$styleVarsId, "data-astro-vars")}>Hello</h1>
+${$$renderComponent($$result,'Component',Component,{"class":"astro-WDOQQKJK"},{"default": () => $$render` + BACKTICK + `<p class="astro-WDOQQKJK"${$$addAttribute($$styleVarsId, "data-astro-vars")}>World</p>` + BACKTICK + `,})}</body></html>`,
+				styleVars: true,
+			},
+		},
+		{
+			name:   "define:vars global",
+			source: `<style global define:vars={{ color: "Gainsboro" }}></style><h1>Hello</h1>`,
+			want: want{
+				styles: []string{`{props:{"global":true,"define:vars":({ color: "Gainsboro" })}}`},
+				code:   `<html><head></head><body><h1>Hello</h1></body></html>`,
 			},
 		},
 		{
@@ -1095,7 +1283,7 @@ import { Container, Col, Row } from 'react-bootstrap';
 `,
 			want: want{
 				frontmatter: []string{`import { Container, Col, Row } from 'react-bootstrap';`},
-				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: 'react-bootstrap', assert: {} }`}},
+				metadata:    metadata{modules: []string{`{ module: $$module1, specifier: "react-bootstrap", assert: {} }`}},
 				code:        "${$$renderComponent($$result,'Container',Container,{},{\"default\": () => $$render`${$$renderComponent($$result,'Row',Row,{},{\"default\": () => $$render`${$$renderComponent($$result,'Col',Col,{},{\"default\": () => $$render`<h1>Hi!</h1>`,})}`,})}`,})}",
 			},
 		},
@@ -1244,9 +1432,9 @@ import ProductPageContent from '../../components/ProductPageContent.jsx';`,
 }`, BACKTICK, BACKTICK),
 				skipHoist: true,
 				metadata: metadata{
-					modules: []string{`{ module: $$module1, specifier: '../../components/Header.jsx', assert: {} }`,
-						`{ module: $$module2, specifier: '../../components/Footer.astro', assert: {} }`,
-						`{ module: $$module3, specifier: '../../components/ProductPageContent.jsx', assert: {} }`,
+					modules: []string{`{ module: $$module1, specifier: "../../components/Header.jsx", assert: {} }`,
+						`{ module: $$module2, specifier: "../../components/Footer.astro", assert: {} }`,
+						`{ module: $$module3, specifier: "../../components/ProductPageContent.jsx", assert: {} }`,
 					},
 					hydratedComponents:  []string{`ProductPageContent`},
 					hydrationDirectives: []string{"visible"},
@@ -1485,7 +1673,7 @@ const items = ["Dog", "Cat", "Platipus"];
 			})
 			output := string(result.Output)
 
-			toMatch := INTERNAL_IMPORTS
+			toMatch := ""
 			if len(tt.want.frontmatter) > 0 {
 				toMatch += test_utils.Dedent(tt.want.frontmatter[0])
 			}
@@ -1494,16 +1682,18 @@ const items = ["Dog", "Cat", "Platipus"];
 				toMatch += "\n\n"
 				toMatch += strings.TrimSpace(test_utils.Dedent(tt.want.getStaticPaths)) + "\n"
 			}
-			moduleSpecRe := regexp.MustCompile(`specifier:\s*('[^']+'),\s*assert:\s*([^}]+\})`)
+			moduleSpecRe := regexp.MustCompile(`specifier:\s*("[^"]+"),\s*assert:\s*([^}]+\})`)
 			if len(tt.want.metadata.modules) > 0 {
 				toMatch += "\n\n"
-				for i, m := range tt.want.metadata.modules {
+				modCount := 1
+				for _, m := range tt.want.metadata.modules {
 					spec := moduleSpecRe.FindSubmatch([]byte(m)) // 0: full match, 1: submatch
 					asrt := ""
 					if string(spec[2]) != "{}" {
 						asrt = " assert " + string(spec[2])
 					}
-					toMatch += fmt.Sprintf("import * as $$module%s from %s%s;\n", strconv.Itoa(i+1), string(spec[1]), asrt)
+					toMatch += fmt.Sprintf("import * as $$module%s from %s%s;\n", strconv.Itoa(modCount), string(spec[1]), asrt)
+					modCount++
 				}
 			}
 			// build metadata object from provided strings
@@ -1519,6 +1709,10 @@ const items = ["Dog", "Cat", "Platipus"];
 				}
 			}
 			metadata += "]"
+			// metadata.dynamicImports
+			if len(tt.want.metadata.dynamicImports) > 0 {
+				metadata += ", dynamicImports: [" + strings.Join(tt.want.metadata.dynamicImports, ", ") + "]"
+			}
 			// metadata.hydratedComponents
 			metadata += ", hydratedComponents: ["
 			if len(tt.want.metadata.hydratedComponents) > 0 {
@@ -1554,7 +1748,11 @@ const items = ["Dog", "Cat", "Platipus"];
 			metadata += "] }"
 
 			toMatch += "\n\n" + fmt.Sprintf("export const %s = %s(import.meta.url, %s);\n\n", METADATA, CREATE_METADATA, metadata)
-			toMatch += test_utils.Dedent(CREATE_ASTRO_CALL) + "\n\n"
+			toMatch += test_utils.Dedent(CREATE_ASTRO_CALL) + "\n"
+			if tt.want.styleVars {
+				toMatch += STYLE_VARS_COUNTER + "\n"
+			}
+			toMatch += "\n"
 			if tt.want.skipHoist != true && len(tt.want.getStaticPaths) > 0 {
 				toMatch += strings.TrimSpace(test_utils.Dedent(tt.want.getStaticPaths)) + "\n\n"
 			}
@@ -1563,6 +1761,9 @@ const items = ["Dog", "Cat", "Platipus"];
 				toMatch += test_utils.Dedent(tt.want.frontmatter[1])
 			}
 			toMatch += "\n"
+			if tt.want.styleVars {
+				toMatch += STYLE_VARS_INSTANCE
+			}
 			if len(tt.want.styles) > 0 {
 				toMatch = toMatch + STYLE_PRELUDE
 				for _, style := range tt.want.styles {
@@ -1584,6 +1785,7 @@ const items = ["Dog", "Cat", "Platipus"];
 				toMatch = strings.TrimRight(toMatch, ".")
 			}
 			toMatch += SUFFIX
+			toMatch = internalImports(toMatch) + toMatch
 
 			// compare to expected string, show diff if mismatch
 			if diff := test_utils.ANSIDiff(test_utils.Dedent(toMatch), test_utils.Dedent(output)); diff != "" {
@@ -1592,3 +1794,472 @@ const items = ["Dog", "Cat", "Platipus"];
 		})
 	}
 }
+
+func TestScopeSVGStyles(t *testing.T) {
+	source := `<svg viewBox="0 0 24 24"><style>.st0{fill:url(#g)}</style><linearGradient id="g" gradientUnits="userSpaceOnUse" /><svg viewBox="0 0 8 8"><path class="st0" /></svg></svg>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := transform.TransformOptions{Scope: "XXXX", ScopeSVGStyles: true}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, opts)
+
+	// <style> tags inside of an <svg> stay in place, so they aren't extracted
+	if css := PrintCSS(source, doc, opts).Output; len(css) != 0 {
+		t.Errorf("expected no extracted CSS, got %q", css)
+	}
+
+	output := string(PrintToJS(source, doc, 0, opts).Output)
+	want := `<svg viewBox="0 0 24 24" class="astro-WYLPTPUY"><style>.st0.astro-WYLPTPUY{fill:url(#g);}</style><linearGradient id="g" gradientUnits="userSpaceOnUse" class="astro-WYLPTPUY"></linearGradient><svg viewBox="0 0 8 8" class="astro-WYLPTPUY"><path class="st0 astro-WYLPTPUY"></path></svg></svg>`
+	if !strings.Contains(output, want) {
+		t.Errorf("expected %s in:\n%s", want, output)
+	}
+}
+
+// Every name the printer declares has to be reserved, so that transform
+// reports user code which uses it
+func TestReservedNames(t *testing.T) {
+	names := []string{
+		TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, RENDER_COMPONENT, RENDER_SLOT, ADD_ATTRIBUTE, SPREAD_ATTRIBUTES,
+		DEFINE_STYLE_VARS, DEFINE_SCRIPT_VARS, CREATE_METADATA, METADATA, RESULT, SLOTS, STYLE_VARS_COUNT,
+		transform.StyleVarsID, PROPS, ASTRO, MODULE + "1", STATIC_SUBTREE + "0",
+	}
+	for _, name := range names {
+		if !transform.IsReservedName(name) {
+			t.Errorf("%s is not reserved", name)
+		}
+	}
+}
+
+func TestPreprocessedSourceMap(t *testing.T) {
+	// What a Sass preprocessor would return for the <style> below
+	code := ".a {\n  color: red;\n}\n"
+	preprocessed := &sourcemap.SourceMap{
+		Sources: []string{"style.scss"},
+		Mappings: []sourcemap.Mapping{
+			{GeneratedLine: 0, GeneratedColumn: 0, OriginalLine: 2, OriginalColumn: 0, NameIndex: -1},
+			{GeneratedLine: 1, GeneratedColumn: 2, OriginalLine: 2, OriginalColumn: 5, NameIndex: -1},
+			{GeneratedLine: 2, GeneratedColumn: 0, OriginalLine: 2, OriginalColumn: 16, NameIndex: -1},
+		},
+	}
+	tests := []struct {
+		name  string
+		attrs string
+		// Code in the output mapped to the code it should point at in the source
+		want map[string]string
+	}{
+		{
+			name:  "global",
+			attrs: ` global lang="scss"`,
+			want: map[string]string{
+				".a {":       ".a { color: $c; }",
+				"color: red": "color: $c; }",
+				"}`":         "}\n</style>",
+			},
+		},
+		{
+			name:  "scoped",
+			attrs: ` lang="scss"`,
+			want: map[string]string{
+				".a.astro-XXXX": ".a { color: $c; }",
+				"color:red":     "color: $c; }",
+				"}`":            "}\n</style>",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "<style" + tt.attrs + ">\n$c: red;\n.a { color: $c; }\n</style>\n<div />"
+			doc, err := astro.Parse(strings.NewReader(source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			transform.ExtractStyles(doc)
+			transform.ApplyPreprocessed(doc.Styles[0], code, preprocessed)
+			transform.Transform(doc, transform.TransformOptions{Scope: "XXXX"})
+			result := PrintToJS(source, doc, 0, transform.TransformOptions{})
+			for generated, original := range tt.want {
+				assertMapsTo(t, string(result.Output), result.SourceMapChunk, source, generated, original)
+			}
+		})
+	}
+}
+
+func TestPreprocessedPartialSourceMap(t *testing.T) {
+	source := "<style global lang=\"scss\">\n@use 'vars';\n.a { color: vars.$c; }\n</style>\n<div />"
+	partial := ".b { color: blue; }"
+	// What Sass would return, with the rule of the partial first
+	code := ".b {\n  color: blue;\n}\n.a {\n  color: red;\n}\n"
+	preprocessed := &sourcemap.SourceMap{
+		Sources: []string{"_vars.scss", "style.scss"},
+		SourcesContent: []sourcemap.SourceContent{
+			{Value: utf16.Encode([]rune(partial))},
+			{Value: utf16.Encode([]rune("\n@use 'vars';\n.a { color: vars.$c; }\n"))},
+		},
+		Mappings: []sourcemap.Mapping{
+			{GeneratedLine: 0, GeneratedColumn: 0, SourceIndex: 0, OriginalLine: 0, OriginalColumn: 0, NameIndex: -1},
+			{GeneratedLine: 1, GeneratedColumn: 2, SourceIndex: 0, OriginalLine: 0, OriginalColumn: 5, NameIndex: -1},
+			{GeneratedLine: 3, GeneratedColumn: 0, SourceIndex: 1, OriginalLine: 2, OriginalColumn: 0, NameIndex: -1},
+			{GeneratedLine: 4, GeneratedColumn: 2, SourceIndex: 1, OriginalLine: 2, OriginalColumn: 5, NameIndex: -1},
+		},
+	}
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform.ExtractStyles(doc)
+	transform.ApplyPreprocessed(doc.Styles[0], code, preprocessed)
+	transform.Transform(doc, transform.TransformOptions{Scope: "XXXX"})
+	result := PrintToJS(source, doc, 0, transform.TransformOptions{})
+	output := string(result.Output)
+	chunk := result.SourceMapChunk
+
+	if diff := test_utils.ANSIDiff([]string{"_vars.scss"}, chunk.Sources); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	if diff := test_utils.ANSIDiff([]string{partial}, chunk.SourcesContent); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+	assertMapsTo(t, output, chunk, source, ".a {", ".a { color")
+	assertMapsTo(t, output, chunk, source, "color: red", "color: vars")
+
+	mappings, err := sourcemap.DecodeMappings(string(chunk.Buffer), 1+len(chunk.Sources), len(chunk.Names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &sourcemap.SourceMap{Mappings: mappings}
+	for _, tt := range []struct {
+		generated string
+		column    int
+	}{
+		{generated: ".b {", column: 0},
+		{generated: "color: blue", column: 5},
+	} {
+		offset := strings.Index(output, tt.generated)
+		line := strings.Count(output[:offset], "\n")
+		mapping := sm.Find(line, offset-strings.LastIndex(output[:offset], "\n")-1)
+		if mapping == nil || mapping.SourceIndex != 1 || mapping.OriginalLine != 0 || mapping.OriginalColumn != tt.column {
+			t.Errorf("expected %q to map to column %d of the partial, got %+v", tt.generated, tt.column, mapping)
+		}
+	}
+}
+
+func TestIdentifierSourceMap(t *testing.T) {
+	source := `---
+import Card from './Card.astro';
+const items = await Astro.glob('./*.md');
+export const prerender = true;
+const title = items.length;
+---
+<h1 class={title}>{items.map((item) => <Card {...item} />)}</h1>
+<style>h1 { color: red; }</style>`
+	tests := []struct {
+		// Where the identifier is in the output and in the source
+		generated string
+		original  string
+		name      string
+	}{
+		{generated: "Card from", original: "Card from", name: "Card"},
+		{generated: "glob(", original: "glob(", name: "glob"},
+		{generated: "length;", original: "length;", name: "length"},
+		{generated: "title) + \" astro-", original: "title}", name: "title"},
+		{generated: "items.map", original: "items.map", name: "items"},
+		{generated: "map((item)", original: "map((item)", name: "map"},
+		{generated: "item) =>", original: "item) =>", name: "item"},
+		{generated: "item),", original: "item} />", name: "item"},
+	}
+
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform.ExtractStyles(doc)
+	transform.Transform(doc, transform.TransformOptions{Scope: "XXXX"})
+	result := PrintToJS(source, doc, 0, transform.TransformOptions{})
+	names := result.SourceMapChunk.Names
+
+	for _, tt := range tests {
+		t.Run(tt.generated, func(t *testing.T) {
+			mapping := assertMapsTo(t, string(result.Output), result.SourceMapChunk, source, tt.generated, tt.original)
+			if mapping != nil && (mapping.NameIndex == -1 || names[mapping.NameIndex] != tt.name) {
+				t.Errorf("expected the name %q, got %d in %v", tt.name, mapping.NameIndex, names)
+			}
+		})
+	}
+}
+
+func TestComponentName(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		override string
+		source   string
+		want     string
+	}{
+		{name: "no filename", source: `<div />`, want: "$$Component"},
+		{name: "stdin", filename: "<stdin>", source: `<div />`, want: "$$Component"},
+		{name: "pascal case", filename: "src/components/UserCard.astro", source: `<div />`, want: "$$UserCard"},
+		{name: "kebab case", filename: "/src/components/user-card.astro", source: `<div />`, want: "$$UserCard"},
+		{name: "dynamic route", filename: "src/pages/[...slug].astro", source: `<div />`, want: "$$Slug"},
+		{name: "digits", filename: "src/pages/404.astro", source: `<div />`, want: "$$404"},
+		{name: "windows path", filename: `C:\src\pages\index.astro`, source: `<div />`, want: "$$Index"},
+		{name: "compiler name", filename: "src/Astro.astro", source: `<div />`, want: "$$Astro2"},
+		{
+			name:     "frontmatter binding",
+			filename: "src/Card.astro",
+			source: `---
+import $$Card from './Other.astro';
+const $$Card2 = 1;
+---
+<div />`,
+			want: "$$Card3",
+		},
+		{name: "template expression", filename: "src/Card.astro", source: `<div>{items.map(($$Card) => $$Card)}</div>`, want: "$$Card2"},
+		{name: "expression attribute", filename: "src/Card.astro", source: `<div title={$$Card} {...$$Card2} />`, want: "$$Card3"},
+		{name: "property names", filename: "src/Card.astro", source: `<div>{Astro.props.$$Card}</div>`, want: "$$Card"},
+		{name: "override", filename: "src/Card.astro", override: "MyCard", source: `<div />`, want: "MyCard"},
+		{name: "invalid override", filename: "src/Card.astro", override: "my card", source: `<div />`, want: "$$Card"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := transform.TransformOptions{Filename: tt.filename, ComponentName: tt.override}
+			transform.ExtractStyles(doc)
+			transform.Transform(doc, opts)
+			output := string(PrintToJS(tt.source, doc, 0, opts).Output)
+			if !strings.Contains(output, "const "+tt.want+" = ") || !strings.HasSuffix(output, "export default "+tt.want+";\n") {
+				t.Errorf("expected the component to be named %s:\n%s", tt.want, output)
+			}
+		})
+	}
+}
+
+func TestInternalImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+		// Code that should map back to the same text in the source
+		mapped string
+	}{
+		{
+			name:   "static",
+			source: `<div />`,
+			want:   []string{TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, CREATE_METADATA},
+		},
+		{
+			name:   "attributes",
+			source: `<div class={value} {...props} />`,
+			want:   []string{TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, ADD_ATTRIBUTE, SPREAD_ATTRIBUTES, CREATE_METADATA},
+			mapped: "value",
+		},
+		{
+			name:   "fragment and slot",
+			source: `<Fragment><slot /></Fragment>`,
+			want:   []string{FRAGMENT, TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, RENDER_COMPONENT, RENDER_SLOT, CREATE_METADATA},
+		},
+		{
+			name: "fragment in frontmatter",
+			source: `---
+const Wrapper = Fragment;
+---
+<div />`,
+			want:   []string{FRAGMENT, TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, CREATE_METADATA},
+			mapped: "Wrapper = Fragment",
+		},
+		{
+			name:   "fragment in an expression",
+			source: `{condition ? Fragment : "div"}`,
+			want:   []string{FRAGMENT, TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, CREATE_METADATA},
+		},
+		{
+			name: "fragment in text and strings",
+			source: `---
+const label = "Fragment";
+const MyFragment = null;
+---
+<p title="Fragment">Fragment {"Fragment"} {MyFragment}</p>`,
+			want: []string{TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, CREATE_METADATA},
+		},
+		{
+			name: "declared fragment",
+			source: `---
+import { Fragment } from "./fragment.js";
+const Wrapper = Fragment;
+---
+<div />`,
+			want: []string{TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, CREATE_METADATA},
+		},
+		{
+			name:   "define:vars",
+			source: `<script define:vars={{ a }}>console.log(a)</script>`,
+			want:   []string{TEMPLATE_TAG, CREATE_ASTRO, CREATE_COMPONENT, DEFINE_SCRIPT_VARS, CREATE_METADATA},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			transform.Transform(doc, transform.TransformOptions{})
+			result := PrintToJS(tt.source, doc, 0, transform.TransformOptions{InternalURL: "astro/internal"})
+			output := string(result.Output)
+
+			end := strings.Index(output, "} from \"astro/internal\";\n")
+			if !strings.HasPrefix(output, "import {\n") || end == -1 {
+				t.Fatalf("expected the output to start with the internal imports:\n%s", output)
+			}
+			got := []string{}
+			for _, specifier := range strings.Split(output[len("import {\n"):end], ",") {
+				fields := strings.Fields(specifier)
+				got = append(got, fields[len(fields)-1])
+			}
+			if diff := test_utils.ANSIDiff(strings.Join(tt.want, "\n"), strings.Join(got, "\n")); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.mapped != "" {
+				assertMapsTo(t, output, result.SourceMapChunk, tt.source, tt.mapped, tt.mapped)
+			}
+		})
+	}
+}
+
+func TestStaticSubtrees(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		// The template the component returns
+		template string
+		// The constants printed after the component
+		constants []string
+	}{
+		{
+			name:     "nothing static",
+			source:   `<div>{a}</div>`,
+			template: "<html><head></head><body><div>${a}</div></body></html>",
+		},
+		{
+			name:      "whole page",
+			source:    `<main><h1>Title</h1><p>Some <em>text</em></p></main>`,
+			template:  "${$$static0}",
+			constants: []string{"<html><head></head><body><main><h1>Title</h1><p>Some <em>text</em></p></main></body></html>"},
+		},
+		{
+			name:      "next to an expression",
+			source:    `<div>{a}<p>Some <em>text</em></p><br /></div>`,
+			template:  "<html><head></head><body><div>${a}${$$static0}<br></div></body></html>",
+			constants: []string{"<p>Some <em>text</em></p>"},
+		},
+		{
+			name:      "computed attributes",
+			source:    `<div>{a}<ul class={list}><li>One</li></ul><ul class="list"><li>Two</li></ul></div>`,
+			template:  `<html><head></head><body><div>${a}<ul${$$addAttribute(list, "class")}>${$$static0}</ul>${$$static1}</div></body></html>`,
+			constants: []string{"<li>One</li>", `<ul class="list"><li>Two</li></ul>`},
+		},
+		{
+			name:      "component children",
+			source:    `<div>{a}<Card><p>Slotted</p></Card><slot><p>Fallback</p></slot></div>`,
+			template:  `<html><head></head><body><div>${a}${$$renderComponent($$result,'Card',Card,{},{"default": () => $$render` + "`${$$static0}`" + `,})}${$$renderSlot($$result,$$slots["default"],$$render` + "`${$$static1}`" + `)}</div></body></html>`,
+			constants: []string{"<p>Slotted</p>", "<p>Fallback</p>"},
+		},
+		{
+			name:      "escaped text",
+			source:    "<div>{a}<p>`\\d+` costs $5</p><pre>\n\n x</pre></div>",
+			template:  "<html><head></head><body><div>${a}${$$static0}${$$static1}</div></body></html>",
+			constants: []string{"<p>\\`\\\\d+\\` costs $5</p>", "<pre>\n\n x</pre>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			transform.Transform(doc, transform.TransformOptions{})
+			result := PrintToJS(tt.source, doc, 0, transform.TransformOptions{StaticSubtrees: true})
+			output := string(result.Output)
+
+			want := fmt.Sprintf("return %s%s%s;\n});\nexport default $$Component;\n", TEMPLATE_TAG, BACKTICK, tt.template+BACKTICK)
+			if len(tt.constants) > 0 {
+				want += "\n"
+			}
+			for i, constant := range tt.constants {
+				want += fmt.Sprintf("const %s%d = %s%s%s%s;\n", STATIC_SUBTREE, i, TEMPLATE_TAG, BACKTICK, constant, BACKTICK)
+			}
+			start := strings.Index(output, "return ")
+			if start == -1 {
+				t.Fatalf("the component doesn't return a template:\n%s", output)
+			}
+			if diff := test_utils.ANSIDiff(want, output[start:]); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStaticSubtreeSourceMap(t *testing.T) {
+	source := `<div>{a}<p>Some <em>text</em></p></div>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform.Transform(doc, transform.TransformOptions{})
+	result := PrintToJS(source, doc, 0, transform.TransformOptions{StaticSubtrees: true})
+
+	for _, tt := range []struct{ generated, original string }{
+		{generated: "${$$static0}", original: "<p>"},
+		{generated: "<p>Some", original: "<p>Some"},
+		{generated: "<em>", original: "<em>"},
+		{generated: "text</em>", original: "text</em>"},
+	} {
+		assertMapsTo(t, string(result.Output), result.SourceMapChunk, source, tt.generated, tt.original)
+	}
+}
+
+func TestStyleImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "scoped",
+			source: `<style>@import "./a.css";</style><style global>@import "./b.css?raw";</style><div />`,
+			want:   `import "./a.css?astro&type=style&scope=XXXX";import "./b.css?raw&astro&type=style";` + "\n",
+		},
+		{
+			name:   "quotes and backslashes",
+			source: `<style global>@import url('./a"b\\c.css');</style><div />`,
+			want:   `import "./a\"b\\c.css?astro&type=style";` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := transform.TransformOptions{Scope: "XXXX", ResolveStyleImports: true, ScopeStyleImports: true}
+			transform.ExtractStyles(doc)
+			transform.Transform(doc, opts)
+			output := string(PrintToJS(tt.source, doc, 0, opts).Output)
+
+			start := strings.Index(output, "import \"./")
+			if start == -1 {
+				t.Fatalf("no style imports in the output:\n%s", output)
+			}
+			got := output[start : strings.Index(output[start:], "\n")+start+1]
+			if got != tt.want {
+				t.Errorf("\n  want: %s\n  got:  %s", tt.want, got)
+			}
+		})
+	}
+}
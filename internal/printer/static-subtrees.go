@@ -0,0 +1,83 @@
+package printer
+
+import (
+	"fmt"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/transform"
+	"golang.org/x/net/html/atom"
+)
+
+// Static subtrees are hoisted into `$$static0`, `$$static1`, and so on
+var STATIC_SUBTREE = transform.StaticSubtreePrefix
+
+// Whether n renders the same HTML every time: it has no expressions,
+// components or slots inside of it, and none of its attributes are computed
+func isStaticSubtree(n *astro.Node) bool {
+	switch n.Type {
+	case astro.TextNode, astro.CommentNode, astro.DoctypeNode:
+		return true
+	case astro.ElementNode:
+		// Checked below
+	default:
+		return false
+	}
+	if n.Expression || n.Fragment || n.Component || n.CustomElement || n.DataAtom == atom.Slot {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if attr.Type != astro.QuotedAttribute && attr.Type != astro.EmptyAttribute {
+			return false
+		}
+		// Slotted elements and `define:vars` are printed depending on their parent
+		if attr.Key == "slot" || attr.Key == "define:vars" {
+			return false
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !isStaticSubtree(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether n should be replaced by a reference to a constant. Only the
+// outermost element of a static subtree is, and only when it has children:
+// a reference to an element without any is no shorter than the element.
+func (p *printer) shouldHoistStaticSubtree(n *astro.Node, opts transform.TransformOptions) bool {
+	return opts.StaticSubtrees && !p.printingStaticSubtrees && n.Type == astro.ElementNode && n.FirstChild != nil && isStaticSubtree(n)
+}
+
+func (p *printer) printStaticSubtreeReference(n *astro.Node) {
+	p.addSourceMapping(n.Loc[0])
+	p.print(fmt.Sprintf("${%s%d}", STATIC_SUBTREE, len(p.staticSubtrees)))
+	p.staticSubtrees = append(p.staticSubtrees, n)
+}
+
+// Declares the constants that the template refers to, after the component.
+// They're rendered with the template tag, like elements inside of an
+// expression are, so the runtime treats them as HTML rather than as text that
+// needs escaping; but that only happens once, when the module is evaluated.
+func (p *printer) printStaticSubtrees(opts transform.TransformOptions) {
+	if len(p.staticSubtrees) == 0 {
+		return
+	}
+	p.printingStaticSubtrees = true
+	p.addNilSourceMapping()
+	p.println("")
+	for i, n := range p.staticSubtrees {
+		p.addNilSourceMapping()
+		p.print(fmt.Sprintf("const %s%d = ", STATIC_SUBTREE, i))
+		p.printTemplateLiteralOpen()
+		render1(p, n, RenderOptions{
+			isRoot:       false,
+			isExpression: false,
+			depth:        0,
+			opts:         opts,
+		})
+		p.printTemplateLiteralClose()
+		p.println(";")
+	}
+	p.printingStaticSubtrees = false
+}
@@ -1,6 +1,8 @@
 package printer
 
 import (
+	"bytes"
+	"encoding/json"
 	"regexp"
 	"strings"
 )
@@ -35,3 +37,12 @@ func escapeSingleQuote(str string) string {
 func encodeDoubleQuote(str string) string {
 	return strings.Replace(str, `"`, "&quot;", -1)
 }
+
+// Returns str as a double-quoted JS string literal
+func quoteString(str string) string {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(str)
+	return strings.TrimSuffix(b.String(), "\n")
+}
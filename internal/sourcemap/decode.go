@@ -0,0 +1,234 @@
+package sourcemap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+type jsonSection struct {
+	Offset struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"offset"`
+	URL string          `json:"url"`
+	Map json.RawMessage `json:"map"`
+}
+
+type jsonInput struct {
+	Version        int           `json:"version"`
+	SourceRoot     string        `json:"sourceRoot"`
+	Sources        []*string     `json:"sources"`
+	SourcesContent []*string     `json:"sourcesContent"`
+	Names          []string      `json:"names"`
+	Mappings       string        `json:"mappings"`
+	Sections       []jsonSection `json:"sections"`
+}
+
+// ParseJSON decodes a version 3 source map, such as one returned by a
+// preprocessor. Index maps with `sections` are flattened into a single map.
+// Segments without an original location don't map back to anything, so
+// they're left out.
+func ParseJSON(data []byte) (*SourceMap, error) {
+	var input jsonInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	if input.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version %d", input.Version)
+	}
+
+	if input.Sections != nil {
+		return parseSections(input.Sections)
+	}
+
+	sm := &SourceMap{
+		Sources:        make([]string, 0, len(input.Sources)),
+		SourcesContent: make([]SourceContent, 0, len(input.Sources)),
+		Names:          input.Names,
+	}
+	sourceRoot := input.SourceRoot
+	if sourceRoot != "" && !strings.HasSuffix(sourceRoot, "/") {
+		sourceRoot += "/"
+	}
+	for i, source := range input.Sources {
+		name := ""
+		if source != nil {
+			name = sourceRoot + *source
+		}
+		sm.Sources = append(sm.Sources, name)
+
+		content := SourceContent{}
+		if i < len(input.SourcesContent) && input.SourcesContent[i] != nil {
+			quoted, _ := json.Marshal(*input.SourcesContent[i])
+			content.Quoted = string(quoted)
+			content.Value = utf16.Encode([]rune(*input.SourcesContent[i]))
+		}
+		sm.SourcesContent = append(sm.SourcesContent, content)
+	}
+
+	mappings, err := DecodeMappings(input.Mappings, len(sm.Sources), len(sm.Names))
+	if err != nil {
+		return nil, err
+	}
+	sm.Mappings = mappings
+	return sm, nil
+}
+
+// Joins the maps of an index map into one, shifting each of them to the
+// offset of its section
+func parseSections(sections []jsonSection) (*SourceMap, error) {
+	sm := &SourceMap{}
+	for _, section := range sections {
+		if section.URL != "" {
+			return nil, errors.New("source map sections with a url are not supported")
+		}
+		inner, err := ParseJSON(section.Map)
+		if err != nil {
+			return nil, err
+		}
+		sourceOffset := len(sm.Sources)
+		nameOffset := len(sm.Names)
+		sm.Sources = append(sm.Sources, inner.Sources...)
+		sm.SourcesContent = append(sm.SourcesContent, inner.SourcesContent...)
+		sm.Names = append(sm.Names, inner.Names...)
+		for _, mapping := range inner.Mappings {
+			// The column offset only applies to the first line of the section
+			if mapping.GeneratedLine == 0 {
+				mapping.GeneratedColumn += section.Offset.Column
+			}
+			mapping.GeneratedLine += section.Offset.Line
+			mapping.SourceIndex += sourceOffset
+			if mapping.NameIndex != -1 {
+				mapping.NameIndex += nameOffset
+			}
+			sm.Mappings = append(sm.Mappings, mapping)
+		}
+	}
+	sortMappings(sm.Mappings)
+	return sm, nil
+}
+
+// DecodeMappings decodes the VLQ-encoded "mappings" of a source map
+func DecodeMappings(mappings string, sourcesCount int, namesCount int) ([]Mapping, error) {
+	decoded, err := decodeMappings(mappings, sourcesCount, namesCount)
+	if err != nil {
+		return nil, err
+	}
+	// Find expects mappings in generated order, which not every tool guarantees
+	sortMappings(decoded)
+	return decoded, nil
+}
+
+// Decodes mappings in the order they're written
+func decodeMappings(mappings string, sourcesCount int, namesCount int) ([]Mapping, error) {
+	encoded := utf16.Encode([]rune(mappings))
+	decoded := make([]Mapping, 0)
+	generatedLine := 0
+	generatedColumn := 0
+	sourceIndex := 0
+	originalLine := 0
+	originalColumn := 0
+	nameIndex := 0
+
+	for i := 0; i < len(encoded); {
+		switch encoded[i] {
+		case ';':
+			generatedLine++
+			generatedColumn = 0
+			i++
+			continue
+		case ',':
+			i++
+			continue
+		}
+
+		// Read up to five fields, which stop at the next segment or line
+		fields := make([]int, 0, 5)
+		for i < len(encoded) && encoded[i] != ',' && encoded[i] != ';' {
+			value, read, ok := DecodeVLQUTF16(encoded[i:])
+			if !ok {
+				return nil, fmt.Errorf("invalid VLQ value at offset %d in the mappings", i)
+			}
+			fields = append(fields, value)
+			i += read
+		}
+
+		switch len(fields) {
+		case 1, 4, 5:
+		default:
+			return nil, fmt.Errorf("invalid segment with %d fields in the mappings", len(fields))
+		}
+
+		generatedColumn += fields[0]
+		if generatedColumn < 0 {
+			return nil, fmt.Errorf("invalid generated column %d in the mappings", generatedColumn)
+		}
+		if len(fields) == 1 {
+			continue
+		}
+
+		sourceIndex += fields[1]
+		originalLine += fields[2]
+		originalColumn += fields[3]
+		if sourceIndex < 0 || sourceIndex >= sourcesCount {
+			return nil, fmt.Errorf("invalid source index %d in the mappings", sourceIndex)
+		}
+		if originalLine < 0 || originalColumn < 0 {
+			return nil, fmt.Errorf("invalid original location %d:%d in the mappings", originalLine, originalColumn)
+		}
+
+		mapping := Mapping{
+			GeneratedLine:   generatedLine,
+			GeneratedColumn: generatedColumn,
+			SourceIndex:     sourceIndex,
+			OriginalLine:    originalLine,
+			OriginalColumn:  originalColumn,
+			NameIndex:       -1,
+		}
+		if len(fields) == 5 {
+			nameIndex += fields[4]
+			if nameIndex < 0 || nameIndex >= namesCount {
+				return nil, fmt.Errorf("invalid name index %d in the mappings", nameIndex)
+			}
+			mapping.NameIndex = nameIndex
+		}
+		decoded = append(decoded, mapping)
+	}
+	return decoded, nil
+}
+
+func sortMappings(mappings []Mapping) {
+	sort.SliceStable(mappings, func(i, j int) bool {
+		a, b := mappings[i], mappings[j]
+		return a.GeneratedLine < b.GeneratedLine || (a.GeneratedLine == b.GeneratedLine && a.GeneratedColumn < b.GeneratedColumn)
+	})
+}
+
+// ByteOffset converts a 0-based line and UTF-16 column, as used by source
+// maps, into a byte offset in text. It returns false when the line doesn't
+// exist. Columns past the end of a line are clamped to it.
+func ByteOffset(text string, line int, column int) (int, bool) {
+	offset := 0
+	for ; line > 0; line-- {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i == -1 {
+			return 0, false
+		}
+		offset += i + 1
+	}
+	for i, c := range text[offset:] {
+		if column <= 0 || c == '\n' {
+			return offset + i, true
+		}
+		if c >= 0x10000 {
+			column -= 2
+		} else {
+			column--
+		}
+	}
+	return len(text), true
+}
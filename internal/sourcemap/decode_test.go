@@ -0,0 +1,181 @@
+package sourcemap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		sources []string
+		names   []string
+		want    []Mapping
+	}{
+		{
+			name:    "mappings",
+			json:    `{"version":3,"sources":["a.scss"],"names":[],"mappings":"AAAA,IAAI;AACJ"}`,
+			sources: []string{"a.scss"},
+			names:   []string{},
+			want: []Mapping{
+				{GeneratedLine: 0, GeneratedColumn: 0, OriginalLine: 0, OriginalColumn: 0, NameIndex: -1},
+				{GeneratedLine: 0, GeneratedColumn: 4, OriginalLine: 0, OriginalColumn: 4, NameIndex: -1},
+				{GeneratedLine: 1, GeneratedColumn: 0, OriginalLine: 1, OriginalColumn: 0, NameIndex: -1},
+			},
+		},
+		{
+			name:    "names and source root",
+			json:    `{"version":3,"sourceRoot":"src","sources":["a.ts","b.ts"],"names":["a","b"],"mappings":"AAAAA,GCAAC;C"}`,
+			sources: []string{"src/a.ts", "src/b.ts"},
+			names:   []string{"a", "b"},
+			want: []Mapping{
+				{GeneratedLine: 0, GeneratedColumn: 0, SourceIndex: 0, NameIndex: 0},
+				{GeneratedLine: 0, GeneratedColumn: 3, SourceIndex: 1, NameIndex: 1},
+			},
+		},
+		{
+			name: "sections",
+			json: `{"version":3,"sections":[
+				{"offset":{"line":0,"column":0},"map":{"version":3,"sources":["a.css"],"names":[],"mappings":"AAAA"}},
+				{"offset":{"line":2,"column":5},"map":{"version":3,"sources":["b.css"],"names":["b"],"mappings":"AAAAA;AACA"}}
+			]}`,
+			sources: []string{"a.css", "b.css"},
+			names:   []string{"b"},
+			want: []Mapping{
+				{GeneratedLine: 0, GeneratedColumn: 0, SourceIndex: 0, NameIndex: -1},
+				{GeneratedLine: 2, GeneratedColumn: 5, SourceIndex: 1, NameIndex: 0},
+				{GeneratedLine: 3, GeneratedColumn: 0, SourceIndex: 1, OriginalLine: 1, NameIndex: -1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, err := ParseJSON([]byte(tt.json))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := test_utils.ANSIDiff(tt.sources, sm.Sources); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+			if diff := test_utils.ANSIDiff(tt.names, sm.Names); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+			if diff := test_utils.ANSIDiff(tt.want, sm.Mappings); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestParseJSONErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+	}{
+		{name: "version", json: `{"version":2,"sources":[],"mappings":""}`},
+		{name: "truncated VLQ", json: `{"version":3,"sources":["a"],"mappings":"AAA/"}`},
+		{name: "fields", json: `{"version":3,"sources":["a"],"mappings":"AA"}`},
+		{name: "source index", json: `{"version":3,"sources":["a"],"mappings":"ACAA"}`},
+		{name: "name index", json: `{"version":3,"sources":["a"],"names":[],"mappings":"AAAAA"}`},
+		{name: "section url", json: `{"version":3,"sections":[{"offset":{"line":0,"column":0},"url":"a.map"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseJSON([]byte(tt.json)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// Encoding a chunk and decoding it again should find every mapping by position
+func TestDecodeChunk(t *testing.T) {
+	source := "a\nbc\nd"
+	output := []byte{}
+	builder := MakeChunkBuilder(nil, GenerateLineOffsetTables(source, 3))
+	output = append(output, "const x = "...)
+	builder.AddSourceMapping(loc.Loc{Start: 2}, output)
+	output = append(output, "bc;\n"...)
+	builder.AddSourceMapping(loc.Loc{Start: 5}, output)
+	output = append(output, "d;"...)
+	chunk := builder.GenerateChunk(output)
+
+	mappings, err := DecodeMappings(string(chunk.Buffer), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := &SourceMap{Sources: []string{"a.astro"}, Mappings: mappings}
+	tests := []struct {
+		line, column                 int
+		originalLine, originalColumn int
+	}{
+		{line: 0, column: 10, originalLine: 1, originalColumn: 0},
+		{line: 0, column: 12, originalLine: 1, originalColumn: 0},
+		{line: 1, column: 0, originalLine: 2, originalColumn: 0},
+	}
+	for _, tt := range tests {
+		mapping := sm.Find(tt.line, tt.column)
+		if mapping == nil {
+			t.Errorf("no mapping at %d:%d", tt.line, tt.column)
+			continue
+		}
+		if mapping.OriginalLine != tt.originalLine || mapping.OriginalColumn != tt.originalColumn {
+			t.Errorf("%d:%d maps to %d:%d, expected %d:%d", tt.line, tt.column, mapping.OriginalLine, mapping.OriginalColumn, tt.originalLine, tt.originalColumn)
+		}
+	}
+}
+
+func TestByteOffset(t *testing.T) {
+	text := "ab\n😀c\nd"
+	tests := []struct {
+		line, column int
+		want         int
+		ok           bool
+	}{
+		{line: 0, column: 0, want: 0, ok: true},
+		{line: 0, column: 9, want: 2, ok: true},
+		{line: 1, column: 2, want: 7, ok: true},
+		{line: 2, column: 1, want: 10, ok: true},
+		{line: 3, column: 0, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := ByteOffset(text, tt.line, tt.column)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%d:%d is byte %d (%v), expected %d (%v)", tt.line, tt.column, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDecodeNamedChunk(t *testing.T) {
+	source := "a + b + a"
+	output := []byte{}
+	builder := MakeChunkBuilder(nil, GenerateLineOffsetTables(source, 1))
+	builder.AddNamedSourceMapping(loc.Loc{Start: 0}, "a", output)
+	output = append(output, "a + "...)
+	builder.AddNamedSourceMapping(loc.Loc{Start: 4}, "b", output)
+	output = append(output, "b"...)
+	builder.AddSourceMapping(loc.Loc{Start: 5}, output)
+	output = append(output, " + "...)
+	builder.AddNamedSourceMapping(loc.Loc{Start: 8}, "a", output)
+	output = append(output, "a"...)
+	chunk := builder.GenerateChunk(output)
+
+	if diff := test_utils.ANSIDiff([]string{"a", "b"}, chunk.Names); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+	mappings, err := DecodeMappings(string(chunk.Buffer), 1, len(chunk.Names))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]int, 0)
+	for _, mapping := range mappings {
+		got = append(got, mapping.NameIndex)
+	}
+	if diff := test_utils.ANSIDiff([]int{0, 1, -1, 0}, got); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+}
@@ -0,0 +1,51 @@
+package sourcemap
+
+import (
+	"strings"
+
+	"github.com/withastro/compiler/internal/loc"
+)
+
+// An InputSourceMap maps code that was generated from other sources, like
+// the output of a preprocessor, back to them. One of those sources, Self,
+// is part of the file that the ChunkBuilder maps to, starting at Start.
+type InputSourceMap struct {
+	Map  *SourceMap
+	Self int
+	// The text of Self, and where it is in the file
+	SelfContent string
+	Start       loc.Loc
+
+	lineOffsetTables []LineOffsetTable
+}
+
+// NewInputSourceMap returns the map of code, which sm maps back to its
+// sources. Self is -1 when none of them is part of the file.
+func NewInputSourceMap(code string, sm *SourceMap, self int, selfContent string, start loc.Loc) *InputSourceMap {
+	return &InputSourceMap{
+		Map:              sm,
+		Self:             self,
+		SelfContent:      selfContent,
+		Start:            start,
+		lineOffsetTables: GenerateLineOffsetTables(code, strings.Count(code, "\n")+1),
+	}
+}
+
+// OriginalLoc returns where offset in the code came from in the file. It
+// returns false when offset isn't mapped, or is mapped into another source.
+func (m *InputSourceMap) OriginalLoc(offset int) (loc.Loc, bool) {
+	line, column := lineAndColumn(m.lineOffsetTables, offset)
+	mapping := m.Map.Find(line, column)
+	if mapping == nil || mapping.SourceIndex != m.Self {
+		return loc.Loc{}, false
+	}
+	return m.originalLoc(mapping)
+}
+
+func (m *InputSourceMap) originalLoc(mapping *Mapping) (loc.Loc, bool) {
+	offset, ok := ByteOffset(m.SelfContent, mapping.OriginalLine, mapping.OriginalColumn)
+	if !ok {
+		return loc.Loc{}, false
+	}
+	return loc.Loc{Start: m.Start.Start + offset}, true
+}
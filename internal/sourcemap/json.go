@@ -0,0 +1,77 @@
+package sourcemap
+
+import (
+	"bytes"
+	b64 "encoding/base64"
+	"encoding/json"
+)
+
+// The fields of a version 3 source map, as described in
+// https://sourcemaps.info/spec.html. Mappings are already VLQ-encoded,
+// like the Buffer of a Chunk.
+type JSONSourceMap struct {
+	File           string
+	SourceRoot     string
+	Sources        []string
+	SourcesContent []string
+	Names          []string
+	Mappings       []byte
+	// Indexes into Sources of generated code that debuggers should skip
+	IgnoreList []int
+}
+
+type jsonSourceMap struct {
+	Version        int      `json:"version"`
+	File           string   `json:"file,omitempty"`
+	SourceRoot     string   `json:"sourceRoot,omitempty"`
+	Sources        []string `json:"sources"`
+	SourcesContent []string `json:"sourcesContent,omitempty"`
+	Names          []string `json:"names"`
+	Mappings       string   `json:"mappings"`
+	IgnoreList     []int    `json:"x_google_ignoreList,omitempty"`
+}
+
+// Marshal encodes the source map as JSON. Every string is escaped, so
+// filenames and source contents can hold any character.
+func (sm JSONSourceMap) Marshal() []byte {
+	m := jsonSourceMap{
+		Version:        3,
+		File:           sm.File,
+		SourceRoot:     sm.SourceRoot,
+		Sources:        sm.Sources,
+		SourcesContent: sm.SourcesContent,
+		Names:          sm.Names,
+		Mappings:       string(sm.Mappings),
+		IgnoreList:     sm.IgnoreList,
+	}
+	// The spec requires these, even when they're empty
+	if m.Sources == nil {
+		m.Sources = []string{}
+	}
+	if m.Names == nil {
+		m.Names = []string{}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	// Source contents are full of `<` and `>`, which don't need escaping here
+	encoder.SetEscapeHTML(false)
+	// Strings, ints and slices of them can't fail to encode
+	encoder.Encode(m)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// DataURL returns the source map as a base64 `data:` URL
+func DataURL(sourceMap []byte) string {
+	return "data:application/json;charset=utf-8;base64," + b64.StdEncoding.EncodeToString(sourceMap)
+}
+
+// InlineComment returns the comment that points JavaScript at an inline source map
+func InlineComment(sourceMap []byte) string {
+	return "//# sourceMappingURL=" + DataURL(sourceMap)
+}
+
+// InlineCSSComment returns the comment that points CSS at an inline source map
+func InlineCSSComment(sourceMap []byte) string {
+	return "/*# sourceMappingURL=" + DataURL(sourceMap) + " */"
+}
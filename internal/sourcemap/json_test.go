@@ -0,0 +1,70 @@
+package sourcemap
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestJSONSourceMapMarshal(t *testing.T) {
+	tests := []struct {
+		name string
+		sm   JSONSourceMap
+		want string
+	}{
+		{
+			name: "empty",
+			sm:   JSONSourceMap{},
+			want: `{"version":3,"sources":[],"names":[],"mappings":""}`,
+		},
+		{
+			name: "escaping",
+			sm: JSONSourceMap{
+				File:           `src/pages/"quoted".astro`,
+				Sources:        []string{`C:\pages\index.astro`},
+				SourcesContent: []string{"<div>\n\t{a}\n</div>"},
+				Mappings:       []byte("AAAA;AACA"),
+			},
+			want: `{"version":3,"file":"src/pages/\"quoted\".astro","sources":["C:\\pages\\index.astro"],"sourcesContent":["<div>\n\t{a}\n</div>"],"names":[],"mappings":"AAAA;AACA"}`,
+		},
+		{
+			name: "all fields",
+			sm: JSONSourceMap{
+				File:       "index.js",
+				SourceRoot: "/src/",
+				Sources:    []string{"index.astro", "internal.js"},
+				Names:      []string{"title"},
+				Mappings:   []byte("AAAAA"),
+				IgnoreList: []int{1},
+			},
+			want: `{"version":3,"file":"index.js","sourceRoot":"/src/","sources":["index.astro","internal.js"],"names":["title"],"mappings":"AAAAA","x_google_ignoreList":[1]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(tt.sm.Marshal())
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestInlineComment(t *testing.T) {
+	sourceMap := []byte(`{"version":3}`)
+	comment := InlineComment(sourceMap)
+	prefix := "//# sourceMappingURL=data:application/json;charset=utf-8;base64,"
+	if !strings.HasPrefix(comment, prefix) {
+		t.Fatalf("unexpected comment %q", comment)
+	}
+	decoded, err := b64.StdEncoding.DecodeString(strings.TrimPrefix(comment, prefix))
+	if err != nil || string(decoded) != string(sourceMap) {
+		t.Errorf("expected %q, got %q", sourceMap, decoded)
+	}
+	if css := InlineCSSComment(sourceMap); !strings.HasPrefix(css, "/*# sourceMappingURL=data:") || !strings.HasSuffix(css, " */") {
+		t.Errorf("unexpected CSS comment %q", css)
+	}
+}
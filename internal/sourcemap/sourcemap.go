@@ -2,6 +2,7 @@ package sourcemap
 
 import (
 	"bytes"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"github.com/withastro/compiler/internal/helpers"
@@ -15,11 +16,13 @@ type Mapping struct {
 	SourceIndex    int // 0-based
 	OriginalLine   int // 0-based
 	OriginalColumn int // 0-based count of UTF-16 code units
+	NameIndex      int // 0-based, or -1 when the mapping has no name
 }
 
 type SourceMap struct {
 	Sources        []string
 	SourcesContent []SourceContent
+	Names          []string
 	Mappings       []Mapping
 }
 
@@ -378,6 +381,10 @@ type SourceMapState struct {
 	SourceIndex     int
 	OriginalLine    int
 	OriginalColumn  int
+
+	// The name is optional, so it's only stored when HasOriginalName is set
+	OriginalName    int
+	HasOriginalName bool
 }
 
 // Source map chunks are computed in parallel for speed. Each chunk is relative
@@ -414,6 +421,12 @@ func AppendSourceMapChunk(j *helpers.Joiner, prevEndState SourceMapState, startS
 	sourceIndex, i := DecodeVLQ(sourceMap, i)
 	originalLine, i := DecodeVLQ(sourceMap, i)
 	originalColumn, i := DecodeVLQ(sourceMap, i)
+	if i < len(sourceMap) && sourceMap[i] != ',' && sourceMap[i] != ';' {
+		var originalName int
+		originalName, i = DecodeVLQ(sourceMap, i)
+		startState.OriginalName += originalName
+		startState.HasOriginalName = true
+	}
 	sourceMap = sourceMap[i:]
 
 	// Rewrite the first mapping to be relative to the end state of the previous
@@ -451,6 +464,12 @@ func appendMappingToBuffer(buffer []byte, lastByte byte, prevState SourceMapStat
 	buffer = append(buffer, EncodeVLQ(currentState.OriginalColumn-prevState.OriginalColumn)...)
 	prevState.OriginalColumn = currentState.OriginalColumn
 
+	// Record the original name, if present
+	if currentState.HasOriginalName {
+		buffer = append(buffer, EncodeVLQ(currentState.OriginalName-prevState.OriginalName)...)
+		prevState.OriginalName = currentState.OriginalName
+	}
+
 	return buffer
 }
 
@@ -552,6 +571,14 @@ func GenerateLineOffsetTables(contents string, approximateLineCount int) []LineO
 type Chunk struct {
 	Buffer []byte
 
+	// The names that mappings in Buffer refer to, in order of their index
+	Names []string
+
+	// The sources that mappings in Buffer refer to besides the file itself,
+	// which is source 0, and their contents
+	Sources        []string
+	SourcesContent []string
+
 	// This end state will be used to rewrite the start of the following source
 	// map chunk so that the delta-encoded VLQ numbers are preserved.
 	EndState SourceMapState
@@ -567,12 +594,20 @@ type Chunk struct {
 type ChunkBuilder struct {
 	inputSourceMap      *SourceMap
 	sourceMap           []byte
+	names               []string
+	namesMap            map[string]int
 	prevLoc             loc.Loc
+	prevName            string
 	prevState           SourceMapState
 	lastGeneratedUpdate int
 	generatedColumn     int
 	hasPrevState        bool
 	lineOffsetTables    []LineOffsetTable
+	// Sources of input source maps that mappings point into, after the file
+	// itself
+	sources        []string
+	sourcesContent []string
+	sourcesMap     map[string]int
 
 	// This is a workaround for a bug in the popular "source-map" library:
 	// https://github.com/mozilla/source-map/issues/261. The library will
@@ -608,19 +643,126 @@ func MakeChunkBuilder(inputSourceMap *SourceMap, lineOffsetTables []LineOffsetTa
 }
 
 func (b *ChunkBuilder) AddSourceMapping(location loc.Loc, output []byte) {
-	if location == b.prevLoc {
+	b.AddNamedSourceMapping(location, "", output)
+}
+
+// AddNamedSourceMapping adds a mapping like AddSourceMapping, and also
+// records the original name of the identifier at location
+func (b *ChunkBuilder) AddNamedSourceMapping(location loc.Loc, name string, output []byte) {
+	if location == b.prevLoc && name == b.prevName {
 		return
 	}
 	b.prevLoc = location
+	b.prevName = name
 
+	originalLine, originalColumn := lineAndColumn(b.lineOffsetTables, location.Start)
+	b.addMapping(name, SourceMapState{
+		OriginalLine:   originalLine,
+		OriginalColumn: originalColumn,
+	}, output)
+}
+
+// AddInputSourceMapping adds a mapping for output which was copied from
+// offset in the code of input. It's remapped through the source map of
+// input, like every mapping is remapped through inputSourceMap. Mappings
+// into other sources than the file itself are kept, and those sources are
+// added to the chunk.
+func (b *ChunkBuilder) AddInputSourceMapping(input *InputSourceMap, offset int, output []byte) {
+	line, column := lineAndColumn(input.lineOffsetTables, offset)
+	mapping := input.Map.Find(line, column)
+	if mapping == nil {
+		return
+	}
+	name := ""
+	if mapping.NameIndex != -1 && mapping.NameIndex < len(input.Map.Names) {
+		name = input.Map.Names[mapping.NameIndex]
+	}
+	if mapping.SourceIndex == input.Self {
+		if location, ok := input.originalLoc(mapping); ok {
+			b.AddNamedSourceMapping(location, name, output)
+		}
+		return
+	}
+	if mapping.SourceIndex < 0 || mapping.SourceIndex >= len(input.Map.Sources) {
+		return
+	}
+
+	// The next mapping into the file itself can't be skipped as a repeat
+	b.prevLoc = loc.Loc{Start: -1}
+	b.prevName = ""
+	state := SourceMapState{
+		SourceIndex:    b.sourceIndex(input.Map, mapping.SourceIndex),
+		OriginalLine:   mapping.OriginalLine,
+		OriginalColumn: mapping.OriginalColumn,
+	}
+	if name != "" {
+		state.OriginalName = b.nameIndex(name)
+		state.HasOriginalName = true
+	}
+	b.updateGeneratedLineAndColumn(output)
+	b.coverLineStart()
+	state.GeneratedLine = b.prevState.GeneratedLine
+	state.GeneratedColumn = b.generatedColumn
+	b.appendMappingWithoutRemapping(state)
+	b.lineStartsWithMapping = true
+}
+
+// Adds a mapping at the current end of output, for a location in the file
+// itself
+func (b *ChunkBuilder) addMapping(name string, currentState SourceMapState, output []byte) {
+	b.updateGeneratedLineAndColumn(output)
+	b.coverLineStart()
+	currentState.GeneratedLine = b.prevState.GeneratedLine
+	currentState.GeneratedColumn = b.generatedColumn
+	b.appendMapping(name, currentState)
+
+	// This line now has a mapping on it, so don't insert another one
+	b.lineStartsWithMapping = true
+}
+
+// If this line doesn't start with a mapping and we're about to add a mapping
+// that's not at the start, insert a mapping first so the line starts with one.
+func (b *ChunkBuilder) coverLineStart() {
+	if b.coverLinesWithoutMappings && !b.lineStartsWithMapping && b.generatedColumn > 0 && b.hasPrevState {
+		b.appendMappingWithoutRemapping(SourceMapState{
+			GeneratedLine:   b.prevState.GeneratedLine,
+			GeneratedColumn: 0,
+			SourceIndex:     b.prevState.SourceIndex,
+			OriginalLine:    b.prevState.OriginalLine,
+			OriginalColumn:  b.prevState.OriginalColumn,
+		})
+	}
+}
+
+// Returns the index in the chunk of a source of an input source map. The
+// file itself is source 0, so the others come after it.
+func (b *ChunkBuilder) sourceIndex(sm *SourceMap, index int) int {
+	source := sm.Sources[index]
+	if i, ok := b.sourcesMap[source]; ok {
+		return i
+	}
+	if b.sourcesMap == nil {
+		b.sourcesMap = make(map[string]int)
+	}
+	content := ""
+	if index < len(sm.SourcesContent) && sm.SourcesContent[index].Value != nil {
+		content = string(utf16.Decode(sm.SourcesContent[index].Value))
+	}
+	b.sources = append(b.sources, source)
+	b.sourcesContent = append(b.sourcesContent, content)
+	b.sourcesMap[source] = len(b.sources)
+	return len(b.sources)
+}
+
+// Converts a byte offset into a 0-based line and UTF-16 column
+func lineAndColumn(lineOffsetTables []LineOffsetTable, offset int) (int, int) {
 	// Binary search to find the line
-	lineOffsetTables := b.lineOffsetTables
 	count := len(lineOffsetTables)
 	originalLine := 0
 	for count > 0 {
 		step := count / 2
 		i := originalLine + step
-		if lineOffsetTables[i].byteOffsetToStartOfLine <= location.Start {
+		if lineOffsetTables[i].byteOffsetToStartOfLine <= offset {
 			originalLine = i + 1
 			count = count - step - 1
 		} else {
@@ -631,34 +773,11 @@ func (b *ChunkBuilder) AddSourceMapping(location loc.Loc, output []byte) {
 
 	// Use the line to compute the column
 	line := &lineOffsetTables[originalLine]
-	originalColumn := int(location.Start - line.byteOffsetToStartOfLine)
+	originalColumn := int(offset - line.byteOffsetToStartOfLine)
 	if line.columnsForNonASCII != nil && originalColumn >= int(line.byteOffsetToFirstNonASCII) {
 		originalColumn = int(line.columnsForNonASCII[originalColumn-int(line.byteOffsetToFirstNonASCII)])
 	}
-
-	b.updateGeneratedLineAndColumn(output)
-
-	// If this line doesn't start with a mapping and we're about to add a mapping
-	// that's not at the start, insert a mapping first so the line starts with one.
-	if b.coverLinesWithoutMappings && !b.lineStartsWithMapping && b.generatedColumn > 0 && b.hasPrevState {
-		b.appendMappingWithoutRemapping(SourceMapState{
-			GeneratedLine:   b.prevState.GeneratedLine,
-			GeneratedColumn: 0,
-			SourceIndex:     b.prevState.SourceIndex,
-			OriginalLine:    b.prevState.OriginalLine,
-			OriginalColumn:  b.prevState.OriginalColumn,
-		})
-	}
-
-	b.appendMapping(SourceMapState{
-		GeneratedLine:   b.prevState.GeneratedLine,
-		GeneratedColumn: b.generatedColumn,
-		OriginalLine:    originalLine,
-		OriginalColumn:  originalColumn,
-	})
-
-	// This line now has a mapping on it, so don't insert another one
-	b.lineStartsWithMapping = true
+	return originalLine, originalColumn
 }
 
 func (b *ChunkBuilder) GenerateChunk(output []byte) Chunk {
@@ -672,6 +791,9 @@ func (b *ChunkBuilder) GenerateChunk(output []byte) Chunk {
 	}
 	return Chunk{
 		Buffer:               b.sourceMap,
+		Names:                b.names,
+		Sources:              b.sources,
+		SourcesContent:       b.sourcesContent,
 		EndState:             b.prevState,
 		FinalGeneratedColumn: b.generatedColumn,
 		ShouldIgnore:         shouldIgnore,
@@ -725,7 +847,7 @@ func (b *ChunkBuilder) updateGeneratedLineAndColumn(output []byte) {
 	b.lastGeneratedUpdate = len(output)
 }
 
-func (b *ChunkBuilder) appendMapping(currentState SourceMapState) {
+func (b *ChunkBuilder) appendMapping(name string, currentState SourceMapState) {
 	// If the input file had a source map, map all the way back to the original
 	if b.inputSourceMap != nil {
 		mapping := b.inputSourceMap.Find(
@@ -740,11 +862,34 @@ func (b *ChunkBuilder) appendMapping(currentState SourceMapState) {
 		currentState.SourceIndex = int(mapping.SourceIndex)
 		currentState.OriginalLine = int(mapping.OriginalLine)
 		currentState.OriginalColumn = int(mapping.OriginalColumn)
+
+		// The name in the original source wins over the one it was renamed to
+		if mapping.NameIndex != -1 && mapping.NameIndex < len(b.inputSourceMap.Names) {
+			name = b.inputSourceMap.Names[mapping.NameIndex]
+		}
 	}
 
+	if name != "" {
+		currentState.OriginalName = b.nameIndex(name)
+		currentState.HasOriginalName = true
+	}
 	b.appendMappingWithoutRemapping(currentState)
 }
 
+// Returns the index of a name in the names of the chunk, adding it if needed
+func (b *ChunkBuilder) nameIndex(name string) int {
+	if index, ok := b.namesMap[name]; ok {
+		return index
+	}
+	if b.namesMap == nil {
+		b.namesMap = make(map[string]int)
+	}
+	index := len(b.names)
+	b.names = append(b.names, name)
+	b.namesMap[name] = index
+	return index
+}
+
 func (b *ChunkBuilder) appendMappingWithoutRemapping(currentState SourceMapState) {
 	var lastByte byte
 	if len(b.sourceMap) != 0 {
@@ -752,6 +897,11 @@ func (b *ChunkBuilder) appendMappingWithoutRemapping(currentState SourceMapState
 	}
 
 	b.sourceMap = appendMappingToBuffer(b.sourceMap, lastByte, b.prevState, currentState)
+	prevOriginalName := b.prevState.OriginalName
 	b.prevState = currentState
+	// Names are delta-encoded against the last mapping that had one
+	if !currentState.HasOriginalName {
+		b.prevState.OriginalName = prevOriginalName
+	}
 	b.hasPrevState = true
 }
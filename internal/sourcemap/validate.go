@@ -0,0 +1,88 @@
+package sourcemap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// A Problem is something wrong with a mapping, or with a line of generated
+// code that has none
+type Problem struct {
+	GeneratedLine   int // 0-based
+	GeneratedColumn int // 0-based count of UTF-16 code units
+	Text            string
+}
+
+// Validate checks the VLQ-encoded mappings of the generated code against
+// the contents of each source. It reports generated lines that have code
+// but no mapping, mappings that point outside of their source, and mappings
+// that come before the previous one on their line. Lines before the first
+// mapping are a preamble that doesn't come from any source, like the imports
+// a compiler adds, so they aren't reported.
+func Validate(mappings string, generated string, sources []string, namesCount int) ([]Problem, error) {
+	decoded, err := decodeMappings(mappings, len(sources), namesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceLines := make([][]string, 0, len(sources))
+	for _, source := range sources {
+		sourceLines = append(sourceLines, strings.Split(source, "\n"))
+	}
+
+	problems := make([]Problem, 0)
+	generatedLines := strings.Split(generated, "\n")
+	mapped := make([]bool, len(generatedLines))
+	preamble := len(generatedLines)
+	for i, mapping := range decoded {
+		if mapping.GeneratedLine < len(mapped) {
+			mapped[mapping.GeneratedLine] = true
+		}
+		if mapping.GeneratedLine < preamble {
+			preamble = mapping.GeneratedLine
+		}
+		if i > 0 {
+			prev := decoded[i-1]
+			if prev.GeneratedLine == mapping.GeneratedLine && prev.GeneratedColumn > mapping.GeneratedColumn {
+				problems = append(problems, Problem{
+					GeneratedLine:   mapping.GeneratedLine,
+					GeneratedColumn: mapping.GeneratedColumn,
+					Text:            fmt.Sprintf("comes before the previous mapping at column %d", prev.GeneratedColumn),
+				})
+			}
+		}
+		lines := sourceLines[mapping.SourceIndex]
+		if mapping.OriginalLine >= len(lines) {
+			problems = append(problems, Problem{
+				GeneratedLine:   mapping.GeneratedLine,
+				GeneratedColumn: mapping.GeneratedColumn,
+				Text:            fmt.Sprintf("points at line %d, but the source only has %d", mapping.OriginalLine+1, len(lines)),
+			})
+			continue
+		}
+		if length := len(utf16.Encode([]rune(lines[mapping.OriginalLine]))); mapping.OriginalColumn > length {
+			problems = append(problems, Problem{
+				GeneratedLine:   mapping.GeneratedLine,
+				GeneratedColumn: mapping.GeneratedColumn,
+				Text:            fmt.Sprintf("points at column %d of line %d, which only has %d", mapping.OriginalColumn, mapping.OriginalLine+1, length),
+			})
+		}
+	}
+
+	for i, line := range generatedLines {
+		if i >= preamble && !mapped[i] && strings.TrimSpace(line) != "" {
+			problems = append(problems, Problem{GeneratedLine: i, Text: "has no mappings"})
+		}
+	}
+	sortProblems(problems)
+	return problems, nil
+}
+
+func sortProblems(problems []Problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		a, b := problems[i], problems[j]
+		return a.GeneratedLine < b.GeneratedLine || (a.GeneratedLine == b.GeneratedLine && a.GeneratedColumn < b.GeneratedColumn)
+	})
+}
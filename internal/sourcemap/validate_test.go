@@ -0,0 +1,87 @@
+package sourcemap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+// Encodes segments of generated column, original line and original column,
+// one slice per generated line
+func encodeSegments(lines [][][3]int) string {
+	out := []byte{}
+	prevLine, prevColumn := 0, 0
+	for i, segments := range lines {
+		if i > 0 {
+			out = append(out, ';')
+		}
+		generatedColumn := 0
+		for j, s := range segments {
+			if j > 0 {
+				out = append(out, ',')
+			}
+			out = append(out, EncodeVLQ(s[0]-generatedColumn)...)
+			out = append(out, EncodeVLQ(0)...)
+			out = append(out, EncodeVLQ(s[1]-prevLine)...)
+			out = append(out, EncodeVLQ(s[2]-prevColumn)...)
+			generatedColumn, prevLine, prevColumn = s[0], s[1], s[2]
+		}
+	}
+	return string(out)
+}
+
+func TestValidate(t *testing.T) {
+	mappings := encodeSegments([][][3]int{
+		{{0, 0, 0}, {3, 0, 2}, {2, 0, 1}},
+		{},
+		{{0, 4, 0}},
+		{{0, 1, 5}},
+	})
+	problems, err := Validate(mappings, "abcd\n\nxy\nzz\nunmapped", []string{"abc\nd"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Problem{
+		{GeneratedLine: 0, GeneratedColumn: 2, Text: "comes before the previous mapping at column 3"},
+		{GeneratedLine: 2, GeneratedColumn: 0, Text: "points at line 5, but the source only has 2"},
+		{GeneratedLine: 3, GeneratedColumn: 0, Text: "points at column 5 of line 2, which only has 1"},
+		{GeneratedLine: 4, GeneratedColumn: 0, Text: "has no mappings"},
+	}
+	if diff := test_utils.ANSIDiff(want, problems); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+}
+
+func TestValidateCompiledOutput(t *testing.T) {
+	mappings := encodeSegments([][][3]int{
+		{{0, 0, 0}, {6, 0, 6}},
+		{{0, 1, 0}},
+	})
+	problems, err := Validate(mappings, "const a = 1;\na;", []string{"const a = 1;\na;"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidatePreamble(t *testing.T) {
+	mappings := encodeSegments([][][3]int{
+		{},
+		{},
+		{{0, 0, 0}},
+		{},
+	})
+	problems, err := Validate(mappings, "import a;\nimport b;\nc;\nunmapped", []string{"c;"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Problem{
+		{GeneratedLine: 3, GeneratedColumn: 0, Text: "has no mappings"},
+	}
+	if diff := test_utils.ANSIDiff(want, problems); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+}
@@ -132,6 +132,9 @@ type Attribute struct {
 	ValLoc    loc.Loc
 	Tokenizer *Tokenizer
 	Type      AttributeType
+	// The part of Val that was copied from the source at ValLoc, when
+	// transform has wrapped it in code of its own
+	ValSource *loc.Span
 }
 
 type Expression struct {
@@ -1825,7 +1828,7 @@ func (z *Tokenizer) Token() Token {
 			var attrType AttributeType
 			var attrTokenizer *Tokenizer = nil
 			key, keyLoc, val, valLoc, attrType, moreAttr = z.TagAttr()
-			t.Attr = append(t.Attr, Attribute{"", atom.String(key), keyLoc, string(val), valLoc, attrTokenizer, attrType})
+			t.Attr = append(t.Attr, Attribute{"", atom.String(key), keyLoc, string(val), valLoc, attrTokenizer, attrType, nil})
 		}
 		if isFragment(string(name)) || isComponent(string(name)) {
 			t.DataAtom, t.Data = 0, string(name)
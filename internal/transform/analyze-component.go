@@ -0,0 +1,242 @@
+package transform
+
+import (
+	"strings"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/js_scanner"
+	"github.com/withastro/compiler/internal/loc"
+	a "golang.org/x/net/html/atom"
+)
+
+// A name bound by an import, like `b` in `import { a as b } from "c"`
+type ImportBinding struct {
+	Imported string `json:"imported"`
+	Local    string `json:"local"`
+}
+
+type ImportAnalysis struct {
+	Specifier string          `json:"specifier"`
+	Kind      string          `json:"kind"`
+	Bindings  []ImportBinding `json:"bindings"`
+	Start     int             `json:"start"`
+	End       int             `json:"end"`
+	// The absolute path of the file, when ResolveSpecifiers found it
+	Resolved string `json:"resolved,omitempty"`
+}
+
+type ExportAnalysis struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+}
+
+// A component or custom element used in the template
+type ComponentUsage struct {
+	Name  string `json:"name"`
+	Start int    `json:"start"`
+	// Hydration directives without the `client:` prefix, like `load` or `only`
+	Directives []string `json:"directives"`
+}
+
+type ComponentAnalysis struct {
+	Imports           []ImportAnalysis `json:"imports"`
+	Exports           []ExportAnalysis `json:"exports"`
+	Components        []ComponentUsage `json:"components"`
+	Slots             []string         `json:"slots"`
+	HasGetStaticPaths bool             `json:"hasGetStaticPaths"`
+}
+
+type AnalyzeResult struct {
+	Analysis     ComponentAnalysis
+	StyleImports []astro.StyleImport
+	Diagnostics  []loc.Diagnostic
+}
+
+// Analyze runs every step of a compile up to code generation and returns
+// what the component uses. Styles aren't preprocessed and no JS or CSS is
+// printed, so this is much cheaper than a full compile when tools only
+// need the metadata.
+func Analyze(source string, opts TransformOptions) (AnalyzeResult, error) {
+	var doc *astro.Node
+	if opts.As == "fragment" {
+		nodes, err := astro.ParseFragment(strings.NewReader(source), &astro.Node{
+			Type:     astro.ElementNode,
+			Data:     a.Template.String(),
+			DataAtom: a.Template,
+		})
+		if err != nil {
+			return AnalyzeResult{}, err
+		}
+		doc = &astro.Node{
+			Type:                astro.DocumentNode,
+			HydrationDirectives: make(map[string]bool),
+		}
+		for _, n := range nodes {
+			doc.AppendChild(n)
+		}
+	} else {
+		var err error
+		if doc, err = astro.Parse(strings.NewReader(source)); err != nil {
+			return AnalyzeResult{}, err
+		}
+	}
+
+	ExtractStyles(doc)
+	Transform(doc, opts)
+	return AnalyzeResult{
+		Analysis:     AnalyzeComponent(doc),
+		StyleImports: doc.StyleImports,
+		Diagnostics:  doc.Diagnostics,
+	}, nil
+}
+
+// Attributes added by AddComponentProps, which aren't part of the source
+var injectedDirectives = map[string]bool{
+	"client:component-hydration": true,
+	"client:component-path":      true,
+	"client:component-export":    true,
+}
+
+// AnalyzeComponent describes what a component depends on and provides, so
+// tools don't have to parse the generated code again. It expects a document
+// which has already been through Transform. All offsets point into the
+// original source.
+func AnalyzeComponent(doc *astro.Node) ComponentAnalysis {
+	analysis := ComponentAnalysis{
+		Imports:    make([]ImportAnalysis, 0),
+		Exports:    make([]ExportAnalysis, 0),
+		Components: make([]ComponentUsage, 0),
+		Slots:      make([]string, 0),
+	}
+
+	if frontmatter, offset := findFrontmatter(doc); frontmatter != nil {
+		for _, statement := range js_scanner.FindImports(frontmatter) {
+			// Type-only imports are erased, so they aren't part of the module graph
+			if statement.IsTypeOnly() {
+				continue
+			}
+			bindings := make([]ImportBinding, 0, len(statement.Imports))
+			for _, imported := range statement.Imports {
+				if imported.IsType {
+					continue
+				}
+				bindings = append(bindings, ImportBinding{Imported: imported.ExportName, Local: imported.LocalName})
+			}
+			analysis.Imports = append(analysis.Imports, ImportAnalysis{
+				Specifier: statement.Specifier,
+				Kind:      statement.Kind.String(),
+				Bindings:  bindings,
+				Start:     offset + statement.Start,
+				End:       offset + statement.End,
+				Resolved:  doc.ResolvedSpecifiers[statement.Specifier],
+			})
+		}
+		for _, exported := range js_scanner.FindExports(frontmatter) {
+			analysis.Exports = append(analysis.Exports, ExportAnalysis{Name: exported.Name, Start: offset + exported.Start})
+			if exported.Name == "getStaticPaths" {
+				analysis.HasGetStaticPaths = true
+			}
+		}
+	}
+
+	slots := make(map[string]bool)
+	walk(doc, func(n *astro.Node) {
+		if n.Type != astro.ElementNode {
+			return
+		}
+		if n.Component || n.CustomElement {
+			usage := ComponentUsage{Name: n.Data, Directives: make([]string, 0)}
+			if len(n.Loc) > 0 {
+				usage.Start = n.Loc[0].Start
+			}
+			for _, attr := range n.Attr {
+				if strings.HasPrefix(attr.Key, "client:") && !injectedDirectives[attr.Key] {
+					usage.Directives = append(usage.Directives, strings.TrimPrefix(attr.Key, "client:"))
+				}
+			}
+			analysis.Components = append(analysis.Components, usage)
+			return
+		}
+		if n.DataAtom == a.Slot {
+			name := "default"
+			if HasAttr(n, "name") {
+				// Slots with a dynamic name can't be known ahead of time
+				if name = GetQuotedAttr(n, "name"); name == "" {
+					return
+				}
+			}
+			if !slots[name] {
+				slots[name] = true
+				analysis.Slots = append(analysis.Slots, name)
+			}
+		}
+	})
+
+	return analysis
+}
+
+// Returns the frontmatter source and its offset in the original source
+func findFrontmatter(doc *astro.Node) ([]byte, int) {
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != astro.FrontmatterNode {
+			continue
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != astro.TextNode {
+				continue
+			}
+			offset := 0
+			if len(c.Loc) > 0 {
+				offset = c.Loc[0].Start
+			}
+			return []byte(c.Data), offset
+		}
+	}
+	return nil, 0
+}
+
+// ReferencesUndeclared reports whether the frontmatter or a template
+// expression references name without the frontmatter declaring it, like
+// `Fragment` which components can use without importing it
+func ReferencesUndeclared(doc *astro.Node, name string) bool {
+	frontmatter, _ := findFrontmatter(doc)
+	for _, statement := range js_scanner.FindBindings(frontmatter) {
+		for _, declaration := range statement.Declarations {
+			if declaration.Name == name {
+				return false
+			}
+		}
+	}
+
+	referenced := false
+	check := func(source string) {
+		// Skip the full scan for code which doesn't mention the name
+		if referenced || !containsIdentifier(source, name) {
+			return
+		}
+		for _, statement := range js_scanner.FindBindings([]byte(source)) {
+			for _, ref := range statement.References {
+				if ref.Name == name {
+					referenced = true
+					return
+				}
+			}
+		}
+	}
+	walk(doc, func(n *astro.Node) {
+		if n.Type == astro.TextNode && n.Parent != nil && (n.Parent.Type == astro.FrontmatterNode || n.Parent.Expression) {
+			check(n.Data)
+		}
+		for _, attr := range n.Attr {
+			switch attr.Type {
+			case astro.ExpressionAttribute:
+				check(attr.Val)
+			case astro.SpreadAttribute, astro.ShorthandAttribute:
+				check(attr.Key)
+			case astro.TemplateLiteralAttribute:
+				check("`" + attr.Val + "`")
+			}
+		}
+	})
+	return referenced
+}
@@ -0,0 +1,155 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestAnalyzeComponent(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   ComponentAnalysis
+	}{
+		{
+			name:   "empty",
+			source: `<div />`,
+			want: ComponentAnalysis{
+				Imports:    []ImportAnalysis{},
+				Exports:    []ExportAnalysis{},
+				Components: []ComponentUsage{},
+				Slots:      []string{},
+			},
+		},
+		{
+			name: "imports and exports",
+			source: `---
+import Counter, { type Props } from '../components/Counter.jsx';
+import type { Theme } from '../theme';
+export { default as Card } from '../components/Card.astro';
+export async function getStaticPaths() {}
+const { data } = await import('../data.json');
+---
+<Counter client:visible />`,
+			want: ComponentAnalysis{
+				Imports: []ImportAnalysis{
+					{Specifier: "../components/Counter.jsx", Kind: "import", Bindings: []ImportBinding{{Imported: "default", Local: "Counter"}}},
+					{Specifier: "../components/Card.astro", Kind: "reexport", Bindings: []ImportBinding{{Imported: "default", Local: "Card"}}},
+					{Specifier: "../data.json", Kind: "dynamic", Bindings: []ImportBinding{}},
+				},
+				Exports:           []ExportAnalysis{{Name: "Card"}, {Name: "getStaticPaths"}},
+				Components:        []ComponentUsage{{Name: "Counter", Directives: []string{"visible"}}},
+				Slots:             []string{},
+				HasGetStaticPaths: true,
+			},
+		},
+		{
+			name: "components",
+			source: `---
+import Layout from '../layouts/Layout.astro';
+import Counter from '../components/Counter.jsx';
+import Chart from '../components/Chart.jsx';
+---
+<Layout>
+  <Counter client:media="(max-width: 600px)" />
+  <Chart client:only="react" />
+  <my-element />
+  <Fragment><Counter /></Fragment>
+</Layout>`,
+			want: ComponentAnalysis{
+				Imports: []ImportAnalysis{
+					{Specifier: "../layouts/Layout.astro", Kind: "import", Bindings: []ImportBinding{{Imported: "default", Local: "Layout"}}},
+					{Specifier: "../components/Counter.jsx", Kind: "import", Bindings: []ImportBinding{{Imported: "default", Local: "Counter"}}},
+					{Specifier: "../components/Chart.jsx", Kind: "import", Bindings: []ImportBinding{{Imported: "default", Local: "Chart"}}},
+				},
+				Exports: []ExportAnalysis{},
+				Components: []ComponentUsage{
+					{Name: "Layout", Directives: []string{}},
+					{Name: "Counter", Directives: []string{"media"}},
+					{Name: "Chart", Directives: []string{"only"}},
+					{Name: "my-element", Directives: []string{}},
+					{Name: "Counter", Directives: []string{}},
+				},
+				Slots: []string{},
+			},
+		},
+		{
+			name: "slots",
+			source: `<header><slot name="header" /></header>
+<main><slot /></main>
+<aside><slot name={name} /></aside>
+<footer><slot name="header" /></footer>`,
+			want: ComponentAnalysis{
+				Imports:    []ImportAnalysis{},
+				Exports:    []ExportAnalysis{},
+				Components: []ComponentUsage{},
+				Slots:      []string{"header", "default"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ExtractStyles(doc)
+			Transform(doc, TransformOptions{})
+			got := AnalyzeComponent(doc)
+
+			// Check that every location points at the right code, then leave it out of the comparison
+			for i, imported := range got.Imports {
+				if text := tt.source[imported.Start:imported.End]; !strings.HasPrefix(text, "import") && !strings.HasPrefix(text, "export") {
+					t.Errorf("wrong location %d-%d for %s", imported.Start, imported.End, imported.Specifier)
+				}
+				got.Imports[i].Start, got.Imports[i].End = 0, 0
+			}
+			for i, exported := range got.Exports {
+				if !strings.HasPrefix(tt.source[exported.Start:], exported.Name) {
+					t.Errorf("wrong location %d for %s", exported.Start, exported.Name)
+				}
+				got.Exports[i].Start = 0
+			}
+			for i, component := range got.Components {
+				if !strings.HasPrefix(tt.source[component.Start:], "<"+component.Name) {
+					t.Errorf("wrong location %d for %s", component.Start, component.Name)
+				}
+				got.Components[i].Start = 0
+			}
+
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	source := `---
+import Counter from '../components/Counter.jsx';
+const { title } = Astro.props;
+export const meta = { title };
+---
+<Counter client:load />`
+	for _, as := range []string{"document", "fragment"} {
+		t.Run(as, func(t *testing.T) {
+			result, err := Analyze(source, TransformOptions{As: as})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Analysis.Components) != 1 || result.Analysis.Components[0].Name != "Counter" {
+				t.Errorf("expected the Counter component, got %v", result.Analysis.Components)
+			}
+			if len(result.Analysis.Exports) != 1 || result.Analysis.Exports[0].Name != "meta" {
+				t.Errorf("expected the meta export, got %v", result.Analysis.Exports)
+			}
+			if len(result.Diagnostics) != 1 || !strings.HasPrefix(source[result.Diagnostics[0].Range.Loc.Start:], "title") {
+				t.Errorf("expected a diagnostic for title, got %v", result.Diagnostics)
+			}
+		})
+	}
+}
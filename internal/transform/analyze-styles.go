@@ -0,0 +1,203 @@
+package transform
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/tdewolff/parse/css"
+	astro "github.com/withastro/compiler/internal"
+)
+
+// A component to include in a project-wide style analysis
+type StyleSource struct {
+	Filename string
+	Source   string
+}
+
+type StyleLocation struct {
+	Filename string `json:"filename"`
+	Start    int    `json:"start"`
+}
+
+// Style blocks with identical content
+type DuplicateStyle struct {
+	Hash      string          `json:"hash"`
+	Locations []StyleLocation `json:"locations"`
+}
+
+// A selector that escapes scoping, defined by more than one component
+type GlobalSelector struct {
+	Selector   string   `json:"selector"`
+	Components []string `json:"components"`
+}
+
+// Components which share the same scope hash, so their styles leak into each other
+type ScopeCollision struct {
+	Scope      string   `json:"scope"`
+	Components []string `json:"components"`
+}
+
+type StyleAnalysis struct {
+	DuplicateStyles []DuplicateStyle `json:"duplicateStyles"`
+	GlobalSelectors []GlobalSelector `json:"globalSelectors"`
+	ScopeCollisions []ScopeCollision `json:"scopeCollisions"`
+}
+
+// Styles are scoped one component at a time. AnalyzeStyles looks at the
+// styles of many components at once to find problems that only show up
+// across the whole project.
+func AnalyzeStyles(sources []StyleSource) (StyleAnalysis, error) {
+	styleHashes := make(map[string][]StyleLocation)
+	globalSelectors := make(map[string][]string)
+	scopes := make(map[string][]string)
+	hashOrder := make([]string, 0)
+	selectorOrder := make([]string, 0)
+	scopeOrder := make([]string, 0)
+
+	for _, source := range sources {
+		doc, err := astro.Parse(strings.NewReader(source.Source))
+		if err != nil {
+			return StyleAnalysis{}, err
+		}
+		ExtractStyles(doc)
+		scope := astro.HashFromSource(source.Source)
+		if _, ok := scopes[scope]; !ok {
+			scopeOrder = append(scopeOrder, scope)
+		}
+		scopes[scope] = append(scopes[scope], source.Filename)
+
+		// doc.Styles is in reverse order
+		for i := len(doc.Styles) - 1; i >= 0; i-- {
+			style := doc.Styles[i]
+			if style.FirstChild == nil || strings.TrimSpace(style.FirstChild.Data) == "" {
+				continue
+			}
+			content := style.FirstChild.Data
+			hash := astro.HashFromSource(strings.TrimSpace(content))
+			if _, ok := styleHashes[hash]; !ok {
+				hashOrder = append(hashOrder, hash)
+			}
+			start := 0
+			if len(style.FirstChild.Loc) > 0 {
+				start = style.FirstChild.Loc[0].Start
+			}
+			styleHashes[hash] = append(styleHashes[hash], StyleLocation{Filename: source.Filename, Start: start})
+
+			scoped := content
+			if !hasTruthyAttr(style, "global") {
+				scoped, _ = scopeCSS(content, TransformOptions{Scope: scope}, false)
+			}
+			for _, selector := range findGlobalSelectors(scoped, scope) {
+				components := globalSelectors[selector]
+				if len(components) == 0 {
+					selectorOrder = append(selectorOrder, selector)
+				}
+				if len(components) == 0 || components[len(components)-1] != source.Filename {
+					globalSelectors[selector] = append(components, source.Filename)
+				}
+			}
+		}
+	}
+
+	analysis := StyleAnalysis{
+		DuplicateStyles: make([]DuplicateStyle, 0),
+		GlobalSelectors: make([]GlobalSelector, 0),
+		ScopeCollisions: make([]ScopeCollision, 0),
+	}
+	for _, hash := range hashOrder {
+		if locations := styleHashes[hash]; len(locations) > 1 {
+			analysis.DuplicateStyles = append(analysis.DuplicateStyles, DuplicateStyle{Hash: hash, Locations: locations})
+		}
+	}
+	for _, selector := range selectorOrder {
+		if components := globalSelectors[selector]; len(components) > 1 {
+			analysis.GlobalSelectors = append(analysis.GlobalSelectors, GlobalSelector{Selector: selector, Components: components})
+		}
+	}
+	for _, scope := range scopeOrder {
+		if components := scopes[scope]; len(components) > 1 {
+			analysis.ScopeCollisions = append(analysis.ScopeCollisions, ScopeCollision{Scope: scope, Components: components})
+		}
+	}
+	return analysis, nil
+}
+
+// Find the selectors in an already-scoped stylesheet which were not scoped.
+// In a scoped style, those come from `:global`, and in a global style it's
+// every selector. Elements which are never scoped, like `body`, are left out.
+func findGlobalSelectors(source string, scope string) []string {
+	p := css.NewParser(bytes.NewBufferString(source), false)
+	selectors := make([]string, 0)
+	keyframesDepth := 0 // keyframe selectors like `from` and `50%` aren't real selectors
+
+	for {
+		gt, _, data := p.Next()
+		switch gt {
+		case css.ErrorGrammar:
+			if len(data) == 0 {
+				return selectors
+			}
+		case css.BeginAtRuleGrammar:
+			if keyframesDepth > 0 || strings.HasSuffix(strings.ToLower(string(data)), "keyframes") {
+				keyframesDepth++
+			}
+		case css.EndAtRuleGrammar:
+			if keyframesDepth > 0 {
+				keyframesDepth--
+			}
+		case css.BeginRulesetGrammar, css.QualifiedRuleGrammar:
+			if keyframesDepth > 0 {
+				continue
+			}
+			list := ""
+			for _, val := range p.Values() {
+				list += string(val.Data)
+			}
+			for _, selector := range splitSelectorList(list) {
+				if selector != "" && !strings.Contains(selector, ".astro-"+scope) && !isNeverScopedSelector(selector) {
+					selectors = append(selectors, selector)
+				}
+			}
+		}
+	}
+}
+
+// Splits `a, b:is(c, d)` into `a` and `b:is(c, d)`
+func splitSelectorList(list string) []string {
+	selectors := make([]string, 0)
+	depth := 0
+	start := 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				selectors = append(selectors, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(selectors, strings.TrimSpace(list[start:]))
+}
+
+// Whether a selector only matches elements which are never scoped, like
+// `html body` or `:root`, ignoring their pseudo-classes
+func isNeverScopedSelector(selector string) bool {
+	compounds := strings.FieldsFunc(selector, func(r rune) bool {
+		return r == ' ' || r == '>' || r == '+' || r == '~'
+	})
+	for _, compound := range compounds {
+		name := compound
+		if i := strings.Index(compound[1:], ":"); i != -1 {
+			name = compound[:i+1]
+		}
+		name = strings.ToLower(name)
+		if strings.ContainsAny(name, ".#[*") || (!NeverScopedElements[name] && !NeverScopedSelectors[name]) {
+			return false
+		}
+	}
+	return len(compounds) > 0
+}
@@ -0,0 +1,90 @@
+package transform
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestAnalyzeStyles(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []StyleSource
+		want    StyleAnalysis
+	}{
+		{
+			name: "no problems",
+			sources: []StyleSource{
+				{Filename: "A.astro", Source: `<style>.a{}</style><div class="a" />`},
+				{Filename: "B.astro", Source: `<style>.b{}</style><div class="b" />`},
+			},
+			want: StyleAnalysis{
+				DuplicateStyles: []DuplicateStyle{},
+				GlobalSelectors: []GlobalSelector{},
+				ScopeCollisions: []ScopeCollision{},
+			},
+		},
+		{
+			name: "duplicate styles",
+			sources: []StyleSource{
+				{Filename: "A.astro", Source: "<style>\n.card{}\n</style><div />"},
+				{Filename: "B.astro", Source: "<p /><style>.card{}</style>"},
+			},
+			want: StyleAnalysis{
+				DuplicateStyles: []DuplicateStyle{{Hash: "BIM2FBCN", Locations: []StyleLocation{{Filename: "A.astro", Start: 7}, {Filename: "B.astro", Start: 12}}}},
+				GlobalSelectors: []GlobalSelector{},
+				ScopeCollisions: []ScopeCollision{},
+			},
+		},
+		{
+			name: "global selectors",
+			sources: []StyleSource{
+				{Filename: "A.astro", Source: `<style>:global(.btn){} .a :global(.btn){}</style><div />`},
+				{Filename: "B.astro", Source: `<style>:global{.btn{}}</style><p />`},
+				{Filename: "C.astro", Source: `<style global>.btn{} @keyframes fade{from{}}</style><span />`},
+				{Filename: "D.astro", Source: `<style>.btn{}</style><span />`},
+			},
+			want: StyleAnalysis{
+				DuplicateStyles: []DuplicateStyle{},
+				GlobalSelectors: []GlobalSelector{{Selector: ".btn", Components: []string{"A.astro", "B.astro", "C.astro"}}},
+				ScopeCollisions: []ScopeCollision{},
+			},
+		},
+		{
+			name: "elements which are never scoped",
+			sources: []StyleSource{
+				{Filename: "A.astro", Source: `<style>body, html{} :root{} html body:hover{} :global(.a), .b{}</style><div />`},
+				{Filename: "B.astro", Source: `<style global>body{} html.dark{} .a{}</style><p />`},
+			},
+			want: StyleAnalysis{
+				DuplicateStyles: []DuplicateStyle{},
+				GlobalSelectors: []GlobalSelector{{Selector: ".a", Components: []string{"A.astro", "B.astro"}}},
+				ScopeCollisions: []ScopeCollision{},
+			},
+		},
+		{
+			name: "scope collisions",
+			sources: []StyleSource{
+				{Filename: "A.astro", Source: `<div />`},
+				{Filename: "copy/A.astro", Source: `<div />`},
+			},
+			want: StyleAnalysis{
+				DuplicateStyles: []DuplicateStyle{},
+				GlobalSelectors: []GlobalSelector{},
+				ScopeCollisions: []ScopeCollision{{Scope: "67UUXSE7", Components: []string{"A.astro", "copy/A.astro"}}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AnalyzeStyles(tt.sources)
+			if err != nil {
+				t.Error(err)
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
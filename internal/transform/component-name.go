@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/js_scanner"
+)
+
+// The name of the component when there's no filename to derive one from
+const DefaultComponentName = "$$Component"
+
+// ComponentName returns the name of the component function, so stack traces
+// and devtools show which component they're about. Unless opts.ComponentName
+// is set, it's derived from the filename, like `$$UserCard` for
+// `src/UserCard.astro`. A number is added to names that the compiler, the
+// frontmatter or the template expressions already use.
+func ComponentName(doc *astro.Node, opts TransformOptions) string {
+	name := opts.ComponentName
+	if !isIdentifier(name) {
+		name = componentNameFromFilename(opts.Filename)
+	}
+
+	used := make(map[string]bool)
+	walkCode(doc, func(source string, start int) {
+		// Every candidate starts with the name, so most code can be skipped
+		if !strings.Contains(source, name) {
+			return
+		}
+		for _, ref := range js_scanner.FindNames([]byte(source)) {
+			used[ref.Name] = true
+		}
+	})
+
+	candidate := name
+	for i := 2; used[candidate] || IsReservedName(candidate); i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	return candidate
+}
+
+// Calls f with the frontmatter and every template expression, including
+// expression attributes, and where in the component each of them starts
+func walkCode(doc *astro.Node, f func(source string, start int)) {
+	walk(doc, func(n *astro.Node) {
+		if n.Type == astro.TextNode && n.Parent != nil && (n.Parent.Type == astro.FrontmatterNode || n.Parent.Expression) {
+			start := 0
+			if len(n.Loc) > 0 {
+				start = n.Loc[0].Start
+			}
+			f(n.Data, start)
+		}
+		for _, attr := range n.Attr {
+			switch attr.Type {
+			case astro.ExpressionAttribute:
+				// Attributes added by the compiler itself don't have a location
+				if attr.ValLoc.Start > 0 {
+					if expr, ok := SourceValue(attr); ok {
+						f(expr, attr.ValLoc.Start)
+					} else {
+						f(attr.Val, attr.ValLoc.Start)
+					}
+				}
+			case astro.SpreadAttribute, astro.ShorthandAttribute:
+				f(attr.Key, attr.KeyLoc.Start)
+			case astro.TemplateLiteralAttribute:
+				f("`"+attr.Val+"`", attr.ValLoc.Start-1)
+			}
+		}
+	})
+}
+
+// `src/components/user-card.astro` becomes `$$UserCard`
+func componentNameFromFilename(filename string) string {
+	if filename == "" || filename == "<stdin>" {
+		return DefaultComponentName
+	}
+	// Filenames may come from Windows, whatever the platform of the compiler
+	base := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	base = strings.TrimSuffix(base, path.Ext(base))
+
+	var b strings.Builder
+	upper := true
+	for _, r := range base {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return DefaultComponentName
+	}
+	return "$$" + b.String()
+}
+
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && r != '$' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/js_scanner"
+	"github.com/withastro/compiler/internal/loc"
+)
+
+// Exports are hoisted out of the render function, so report any of them
+// that use something which only exists inside of it
+func addRenderScopeDiagnostics(doc *astro.Node) {
+	frontmatter, offset := findFrontmatter(doc)
+	for _, ref := range js_scanner.FindRenderScopeReferences(frontmatter) {
+		doc.Diagnostics = append(doc.Diagnostics, loc.Diagnostic{
+			Severity: loc.SeverityError,
+			Text:     fmt.Sprintf("Exports are hoisted out of the component, so they can't reference `%s`", ref.Name),
+			Range:    loc.Range{Loc: loc.Loc{Start: offset + ref.Start}, Len: len(ref.Name)},
+		})
+	}
+}
+
+// The default export of the module is the component, so the frontmatter
+// can't have one of its own
+func addDefaultExportDiagnostics(doc *astro.Node) {
+	frontmatter, offset := findFrontmatter(doc)
+	for _, ref := range js_scanner.FindExports(frontmatter) {
+		if ref.Name != "default" {
+			continue
+		}
+		doc.Diagnostics = append(doc.Diagnostics, loc.Diagnostic{
+			Severity: loc.SeverityError,
+			Text:     "The component is the default export, so the frontmatter can't export `default`",
+			Range:    loc.Range{Loc: loc.Loc{Start: offset + ref.Start}, Len: len(ref.Name)},
+		})
+	}
+}
+
+// Scoped `define:vars` is printed as a rule built from the object, so any
+// other kind of value would be dropped
+func addStyleVarsDiagnostics(doc *astro.Node) {
+	for _, style := range doc.Styles {
+		if !IsScopedStyleVars(style) {
+			continue
+		}
+		for _, attr := range style.Attr {
+			if attr.Key != "define:vars" || attr.Type == astro.ExpressionAttribute {
+				continue
+			}
+			doc.Diagnostics = append(doc.Diagnostics, loc.Diagnostic{
+				Severity: loc.SeverityError,
+				Text:     "`define:vars` expects an object expression, like `define:vars={{ color }}`",
+				Range:    loc.Range{Loc: attr.KeyLoc, Len: len(attr.Key)},
+			})
+		}
+	}
+}
+
+// A component tag that isn't imported or declared compiles fine, but throws
+// when it's rendered. Report every tag whose name doesn't resolve, using the
+// first part of dotted names like `Foo.Bar`.
+func addUnresolvedComponentDiagnostics(doc *astro.Node) {
+	frontmatter, _ := findFrontmatter(doc)
+	// `Astro` is always in scope, and `<Astro.self />` renders the component recursively
+	declared := map[string]bool{"Astro": true}
+	for _, statement := range js_scanner.FindBindings(frontmatter) {
+		for _, declaration := range statement.Declarations {
+			declared[declaration.Name] = true
+		}
+	}
+
+	walk(doc, func(n *astro.Node) {
+		if n.Type != astro.ElementNode || !n.Component {
+			return
+		}
+		name := strings.Split(n.Data, ".")[0]
+		if declared[name] || isDeclaredInExpression(n, name) {
+			return
+		}
+		diagnostic := loc.Diagnostic{
+			Severity: loc.SeverityWarning,
+			Text:     fmt.Sprintf("`%s` is not imported or declared in the frontmatter", name),
+		}
+		if len(n.Loc) > 0 {
+			// Point at the name, right after the `<`
+			diagnostic.Range = loc.Range{Loc: loc.Loc{Start: n.Loc[0].Start + 1}, Len: len(name)}
+		}
+		doc.Diagnostics = append(doc.Diagnostics, diagnostic)
+	})
+}
+
+// Components can also come from an expression around them, like the
+// parameter in `{items.map((Item) => <Item />)}`. Scopes aren't tracked,
+// so any mention of the name in a surrounding expression counts.
+func isDeclaredInExpression(n *astro.Node, name string) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if !p.Expression {
+			continue
+		}
+		for c := p.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == astro.TextNode && containsIdentifier(c.Data, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsIdentifier(source string, name string) bool {
+	for i := strings.Index(source, name); i != -1; {
+		end := i + len(name)
+		if (i == 0 || !isIdentifierChar(source[i-1])) && (end == len(source) || !isIdentifierChar(source[end])) {
+			return true
+		}
+		next := strings.Index(source[i+1:], name)
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+	return false
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// Report every name in the frontmatter and template expressions which
+// collides with one that the printer generates. The component function's
+// name is chosen to avoid them, see ComponentName.
+func addReservedNameDiagnostics(doc *astro.Node) {
+	walkCode(doc, func(source string, start int) {
+		// Most components never use a `$$` name, so skip the full scan for them
+		if !js_scanner.AccessesPrivateVars([]byte(source)) {
+			return
+		}
+		for _, ref := range js_scanner.FindNames([]byte(source)) {
+			if !IsReservedName(ref.Name) {
+				continue
+			}
+			doc.Diagnostics = append(doc.Diagnostics, loc.Diagnostic{
+				Severity: loc.SeverityError,
+				Text:     fmt.Sprintf("`%s` is reserved by the compiler and can't be used as a name", ref.Name),
+				Range:    loc.Range{Loc: loc.Loc{Start: start + ref.Start}, Len: len(ref.Name)},
+			})
+		}
+	})
+}
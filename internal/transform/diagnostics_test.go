@@ -0,0 +1,252 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestUnresolvedComponentDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name: "imported",
+			source: `---
+import Button from '../components/Button.astro';
+import * as Icons from '../components/icons';
+---
+<Button /><Icons.Star />`,
+			want: []string{},
+		},
+		{
+			name: "typo",
+			source: `---
+import Button from '../components/Button.astro';
+---
+<Buton />`,
+			want: []string{"Buton"},
+		},
+		{
+			name: "dotted",
+			source: `---
+import Button from '../components/Button.astro';
+---
+<Form.Input />`,
+			want: []string{"Form"},
+		},
+		{
+			name: "declared in frontmatter",
+			source: `---
+const { as: Tag = 'div' } = Astro.props;
+const Heading = Astro.props.level > 1 ? 'h2' : 'h1';
+---
+<Tag><Heading /></Tag>`,
+			want: []string{},
+		},
+		{
+			name:   "recursive",
+			source: `<ul><Astro.self items={[]} /></ul>`,
+			want:   []string{},
+		},
+		{
+			name:   "expression",
+			source: `{items.map((Item) => <Item />)}<Other />`,
+			want:   []string{"Other"},
+		},
+		{
+			name:   "custom elements and fragments",
+			source: `<my-element /><Fragment />`,
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ExtractStyles(doc)
+			Transform(doc, TransformOptions{})
+			got := make([]string, 0)
+			for _, diagnostic := range doc.Diagnostics {
+				name := tt.source[diagnostic.Range.Loc.Start:diagnostic.Range.End()]
+				if !strings.Contains(diagnostic.Text, "`"+name+"`") {
+					t.Errorf("wrong location %d for %q", diagnostic.Range.Loc.Start, diagnostic.Text)
+				}
+				got = append(got, name)
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestReservedNameDiagnostics(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		filename string
+		want     []string
+	}{
+		{
+			name: "frontmatter",
+			source: `---
+const $$result = 1;
+const $$module2 = import('./a.js');
+const $$static0 = '';
+const $$mine = $$module + $$statics;
+---
+<div />`,
+			want: []string{"$$result", "$$module2", "$$static0"},
+		},
+		{
+			name:   "template expressions",
+			source: `<div a={$$metadata} {...$$props} b={` + "`${$$slots}`" + `}>{items.map(($$render) => <span>{$$render}</span>)}</div>`,
+			want:   []string{"$$metadata", "$$props", "$$slots", "$$render", "$$render"},
+		},
+		{
+			name: "properties and keys",
+			source: `---
+const a = { $$result: 1, $$slots() {} };
+const b = a.$$result + a?.$$slots();
+---
+<div>{a.$$render}</div>`,
+			want: []string{},
+		},
+		{
+			name:     "component name",
+			filename: "src/Card.astro",
+			source:   `<div>{$$Card}{$$Component}</div>`,
+			want:     []string{},
+		},
+		{
+			name: "names added by the compiler",
+			source: `---
+import Counter from './Counter.jsx';
+const color = 'red';
+---
+<Counter client:load />
+<div />
+<style define:vars={{ color }}>div { color: var(--color); }</style>`,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Analyze(tt.source, TransformOptions{Scope: "XXXX", Filename: tt.filename})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, diagnostic := range result.Diagnostics {
+				if !strings.Contains(diagnostic.Text, "reserved") {
+					continue
+				}
+				name := tt.source[diagnostic.Range.Loc.Start:diagnostic.Range.End()]
+				if !strings.Contains(diagnostic.Text, "`"+name+"`") {
+					t.Errorf("wrong location %d for %q", diagnostic.Range.Loc.Start, diagnostic.Text)
+				}
+				got = append(got, name)
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestDefaultExportDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "export default",
+			source: "---\nexport default function helper() {}\n---\n<div />",
+			want:   []string{"default"},
+		},
+		{
+			name:   "export as default",
+			source: "---\nconst a = 1;\nexport { a as default };\nexport * as default from './b.js';\n---\n<div />",
+			want:   []string{"default", "default"},
+		},
+		{
+			name:   "named exports",
+			source: "---\nexport const a = 1;\nexport { default as b } from './b.js';\n---\n<div />",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Analyze(tt.source, TransformOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, diagnostic := range result.Diagnostics {
+				if !strings.Contains(diagnostic.Text, "can't export `default`") {
+					continue
+				}
+				got = append(got, tt.source[diagnostic.Range.Loc.Start:diagnostic.Range.End()])
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
+
+func TestStyleVarsDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "object expression",
+			source: "<style define:vars={{ color }}>div { color: var(--color); }</style><div />",
+			want:   []string{},
+		},
+		{
+			name:   "quoted value",
+			source: "<style define:vars=\"color\">div { color: var(--color); }</style><div />",
+			want:   []string{"define:vars"},
+		},
+		{
+			name:   "empty value",
+			source: "<style define:vars>div { color: var(--color); }</style><div />",
+			want:   []string{"define:vars"},
+		},
+		{
+			name:   "global",
+			source: "<style global define:vars=\"color\">div { color: var(--color); }</style><div />",
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Analyze(tt.source, TransformOptions{Scope: "XXXX"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0)
+			for _, diagnostic := range result.Diagnostics {
+				if !strings.Contains(diagnostic.Text, "define:vars") {
+					continue
+				}
+				got = append(got, tt.source[diagnostic.Range.Loc.Start:diagnostic.Range.End()])
+			}
+			if diff := test_utils.ANSIDiff(tt.want, got); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
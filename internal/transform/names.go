@@ -0,0 +1,66 @@
+package transform
+
+import "strings"
+
+// Names the printer declares in the module it generates
+const (
+	TemplateTag      = "$$render"
+	CreateAstro      = "$$createAstro"
+	CreateComponent  = "$$createComponent"
+	RenderComponent  = "$$renderComponent"
+	RenderSlot       = "$$renderSlot"
+	AddAttribute     = "$$addAttribute"
+	SpreadAttributes = "$$spreadAttributes"
+	DefineStyleVars  = "$$defineStyleVars"
+	DefineScriptVars = "$$defineScriptVars"
+	CreateMetadata   = "$$createMetadata"
+	Metadata         = "$$metadata"
+	Result           = "$$result"
+	Slots            = "$$slots"
+	Props            = "$$props"
+	TopLevelAstro    = "$$Astro"
+	StyleVarsCount   = "$$styleVarsCount"
+	// Followed by a number, like `$$module1`
+	ModulePrefix = "$$module"
+	// Followed by a number, like `$$static0`
+	StaticSubtreePrefix = "$$static"
+)
+
+// User code which uses one of these names would shadow, or be shadowed by,
+// the compiler's own
+var reservedNames = map[string]bool{
+	TemplateTag:      true,
+	CreateAstro:      true,
+	CreateComponent:  true,
+	RenderComponent:  true,
+	RenderSlot:       true,
+	AddAttribute:     true,
+	SpreadAttributes: true,
+	DefineStyleVars:  true,
+	DefineScriptVars: true,
+	CreateMetadata:   true,
+	Metadata:         true,
+	Result:           true,
+	Slots:            true,
+	Props:            true,
+	TopLevelAstro:    true,
+	StyleVarsCount:   true,
+	StyleVarsID:      true,
+}
+
+// IsReservedName reports whether the printer may declare name. Imported
+// modules are named `$$module1`, `$$module2`, and so on, and static subtrees
+// `$$static0`, `$$static1`, ... The component function's own name is not
+// included, since it depends on the component, see ComponentName.
+func IsReservedName(name string) bool {
+	if reservedNames[name] {
+		return true
+	}
+	for _, prefix := range []string{ModulePrefix, StaticSubtreePrefix} {
+		index := strings.TrimPrefix(name, prefix)
+		if index != name && index != "" && strings.Trim(index, "0123456789") == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package transform
+
+import (
+	"sort"
+	"unicode/utf16"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/sourcemap"
+)
+
+// ApplyPreprocessed swaps the text of a <style> or <script> for the code a
+// preprocessor returned. When it also returned a source map, the printer
+// remaps the code through it, so the final source map points at the
+// original source rather than at the preprocessed code. Mappings into other
+// files, like a Sass partial, keep pointing at them.
+func ApplyPreprocessed(n *astro.Node, code string, sm *sourcemap.SourceMap) {
+	text := n.FirstChild
+	if text == nil {
+		return
+	}
+	original := text.Data
+	text.Data = code
+	text.SourceMappings = nil
+	text.InputSourceMap = nil
+	if sm == nil || len(text.Loc) == 0 {
+		return
+	}
+
+	text.InputSourceMap = sourcemap.NewInputSourceMap(code, sm, preprocessedSourceIndex(sm, original), original, text.Loc[0])
+	// Every offset that the map has a segment at gets a mapping to itself,
+	// until scoping or removing an @import changes the code
+	for _, m := range sm.Mappings {
+		generated, ok := sourcemap.ByteOffset(code, m.GeneratedLine, m.GeneratedColumn)
+		if !ok {
+			continue
+		}
+		if last := len(text.SourceMappings) - 1; last >= 0 && text.SourceMappings[last].Generated >= generated {
+			continue
+		}
+		text.SourceMappings = append(text.SourceMappings, astro.SourceMapping{Generated: generated, Original: generated})
+	}
+}
+
+// Returns the offset in the preprocessed code that offset in a text was
+// copied from, using the closest mapping at or before it
+func preprocessedOffset(mappings []astro.SourceMapping, offset int) int {
+	i := sort.Search(len(mappings), func(i int) bool { return mappings[i].Generated > offset }) - 1
+	if i < 0 {
+		return offset
+	}
+	return mappings[i].Original + offset - mappings[i].Generated
+}
+
+// Returns the index of the source that holds the text which was preprocessed.
+// Preprocessors don't agree on what to name it, so sources are matched by
+// their content, falling back to the first one.
+func preprocessedSourceIndex(sm *sourcemap.SourceMap, original string) int {
+	for i, content := range sm.SourcesContent {
+		if content.Value != nil && string(utf16.Decode(content.Value)) == original {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,79 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/internal/sourcemap"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestApplyPreprocessed(t *testing.T) {
+	source := "<style lang=\"scss\">\n@use 'vars';\n.a { color: vars.$c; }\n</style>"
+	content := "\n@use 'vars';\n.a { color: vars.$c; }\n"
+	code := ".b {\n  color: red;\n}\n.a {\n  color: red;\n}\n"
+	// The partial comes first, which is why sources are matched by content
+	sm := &sourcemap.SourceMap{
+		Sources: []string{"_vars.scss", "style.scss"},
+		SourcesContent: []sourcemap.SourceContent{
+			{Value: utf16.Encode([]rune(".b { color: red; }"))},
+			{Value: utf16.Encode([]rune(content))},
+		},
+		Mappings: []sourcemap.Mapping{
+			{GeneratedLine: 0, GeneratedColumn: 0, SourceIndex: 0, NameIndex: -1},
+			{GeneratedLine: 3, GeneratedColumn: 0, SourceIndex: 1, OriginalLine: 2, OriginalColumn: 0, NameIndex: -1},
+			{GeneratedLine: 4, GeneratedColumn: 2, SourceIndex: 1, OriginalLine: 2, OriginalColumn: 5, NameIndex: -1},
+			{GeneratedLine: 9, GeneratedColumn: 0, SourceIndex: 1, OriginalLine: 2, OriginalColumn: 0, NameIndex: -1},
+		},
+	}
+
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExtractStyles(doc)
+	style := doc.Styles[0]
+	ApplyPreprocessed(style, code, sm)
+
+	text := style.FirstChild
+	if text.Data != code {
+		t.Errorf("expected the preprocessed code, got %q", text.Data)
+	}
+	// Until the code changes, each mapping points at itself
+	want := []astro.SourceMapping{
+		{Generated: 0, Original: 0},
+		{Generated: strings.Index(code, ".a {"), Original: strings.Index(code, ".a {")},
+		{Generated: strings.LastIndex(code, "color"), Original: strings.LastIndex(code, "color")},
+	}
+	if diff := test_utils.ANSIDiff(want, text.SourceMappings); diff != "" {
+		t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+	}
+
+	offset := strings.Index(source, ".a {")
+	tests := []struct {
+		generated int
+		want      loc.Loc
+		ok        bool
+	}{
+		{generated: strings.Index(code, ".a {"), want: loc.Loc{Start: offset}, ok: true},
+		{generated: strings.LastIndex(code, "color"), want: loc.Loc{Start: offset + 5}, ok: true},
+		// The partial isn't part of the component
+		{generated: 0, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := text.InputSourceMap.OriginalLoc(tt.generated)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("expected %d to map to %v (%v), got %v (%v)", tt.generated, tt.want, tt.ok, got, ok)
+		}
+	}
+
+	// Without a source map, stale mappings are dropped
+	ApplyPreprocessed(style, code, nil)
+	if len(text.SourceMappings) != 0 || text.InputSourceMap != nil {
+		t.Errorf("expected no mappings, got %v", text.SourceMappings)
+	}
+}
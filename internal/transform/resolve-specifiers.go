@@ -0,0 +1,260 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/js_scanner"
+	"github.com/withastro/compiler/internal/loc"
+)
+
+// Extensions that may be left out of a specifier, in the order they're tried
+var resolvableExtensions = []string{".astro", ".ts", ".tsx", ".mts", ".js", ".jsx", ".mjs", ".cjs", ".json", ".md", ".mdx", ".svelte", ".vue"}
+
+// TypeScript lets `.js` specifiers point at the `.ts` file they're compiled from
+var typescriptExtensions = map[string][]string{
+	".js":  {".ts", ".tsx"},
+	".jsx": {".tsx"},
+	".mjs": {".mts"},
+	".cjs": {".cts"},
+}
+
+// Look up the relative and tsconfig `paths` specifiers of the frontmatter on
+// disk. Missing files are reported as diagnostics, and resolved files are
+// recorded in doc.ResolvedSpecifiers. Bare specifiers belong to packages,
+// which are left to the bundler.
+func ResolveSpecifiers(doc *astro.Node, opts TransformOptions) {
+	frontmatter, offset := findFrontmatter(doc)
+	if frontmatter == nil {
+		return
+	}
+
+	projectRoot := opts.ProjectRoot
+	if projectRoot == "" {
+		projectRoot = "."
+	}
+	// Without a real filename, relative specifiers can't be resolved
+	dir := ""
+	if opts.Filename != "" && opts.Filename != "<stdin>" {
+		filename := opts.Filename
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(projectRoot, filename)
+		}
+		dir = filepath.Dir(filename)
+	}
+	paths := readTSConfigPaths(projectRoot)
+
+	for _, imported := range js_scanner.FindImports(frontmatter) {
+		if imported.IsTypeOnly() {
+			continue
+		}
+		// `?raw` and `#hash` suffixes are for the bundler, not part of the path
+		specifier := imported.Specifier
+		if i := strings.IndexAny(specifier, "?#"); i != -1 {
+			specifier = specifier[:i]
+		}
+
+		var candidates []string
+		// A catch-all `*` alias falls back to packages when nothing matches
+		isPackage := false
+		if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+			if dir == "" {
+				continue
+			}
+			candidates = []string{filepath.Join(dir, filepath.FromSlash(specifier))}
+		} else if path, ok := paths.match(specifier); ok {
+			candidates = path.candidates(specifier)
+			isPackage = path.prefixLength() == 0
+		} else {
+			continue
+		}
+
+		resolved := ""
+		for _, candidate := range candidates {
+			if resolved = resolveFile(candidate); resolved != "" {
+				break
+			}
+		}
+		if resolved == "" {
+			if isPackage {
+				continue
+			}
+			doc.Diagnostics = append(doc.Diagnostics, loc.Diagnostic{
+				Severity: loc.SeverityError,
+				Text:     fmt.Sprintf("Could not resolve `%s`", imported.Specifier),
+				Range:    loc.Range{Loc: loc.Loc{Start: offset + imported.Start}, Len: imported.End - imported.Start},
+			})
+			continue
+		}
+		if abs, err := filepath.Abs(resolved); err == nil {
+			resolved = abs
+		}
+		if doc.ResolvedSpecifiers == nil {
+			doc.ResolvedSpecifiers = make(map[string]string)
+		}
+		doc.ResolvedSpecifiers[imported.Specifier] = resolved
+	}
+}
+
+// Returns the file that a path without an extension, or a directory, refers to
+func resolveFile(path string) string {
+	if isFile(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	for _, replacement := range typescriptExtensions[ext] {
+		if candidate := strings.TrimSuffix(path, ext) + replacement; isFile(candidate) {
+			return candidate
+		}
+	}
+	for _, ext := range resolvableExtensions {
+		if isFile(path + ext) {
+			return path + ext
+		}
+	}
+	for _, ext := range resolvableExtensions {
+		if candidate := filepath.Join(path, "index"+ext); isFile(candidate) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// The `compilerOptions.paths` aliases of a tsconfig.json, with every
+// target already joined to the directory it's relative to
+type tsconfigPaths []tsconfigPath
+
+type tsconfigPath struct {
+	pattern string
+	targets []string
+}
+
+func (path tsconfigPath) prefixLength() int {
+	if i := strings.IndexByte(path.pattern, '*'); i != -1 {
+		return i
+	}
+	return math.MaxInt32
+}
+
+// Returns the first alias that matches a specifier
+func (paths tsconfigPaths) match(specifier string) (tsconfigPath, bool) {
+	for _, path := range paths {
+		if _, ok := path.wildcard(specifier); ok {
+			return path, true
+		}
+	}
+	return tsconfigPath{}, false
+}
+
+// Returns the part of the specifier matched by the `*` of the pattern
+func (path tsconfigPath) wildcard(specifier string) (string, bool) {
+	i := strings.IndexByte(path.pattern, '*')
+	if i == -1 {
+		return "", path.pattern == specifier
+	}
+	prefix, suffix := path.pattern[:i], path.pattern[i+1:]
+	if len(specifier) < len(prefix)+len(suffix) || !strings.HasPrefix(specifier, prefix) || !strings.HasSuffix(specifier, suffix) {
+		return "", false
+	}
+	return specifier[len(prefix) : len(specifier)-len(suffix)], true
+}
+
+// Returns the paths a specifier could point at, in the order they're tried
+func (path tsconfigPath) candidates(specifier string) []string {
+	wildcard, _ := path.wildcard(specifier)
+	candidates := make([]string, 0, len(path.targets))
+	for _, target := range path.targets {
+		candidates = append(candidates, strings.Replace(target, "*", wildcard, 1))
+	}
+	return candidates
+}
+
+// Reads the `paths` of the tsconfig.json (or jsconfig.json) at the project root
+func readTSConfigPaths(projectRoot string) tsconfigPaths {
+	for _, name := range []string{"tsconfig.json", "jsconfig.json"} {
+		filename := filepath.Join(projectRoot, name)
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			continue
+		}
+		var config struct {
+			CompilerOptions struct {
+				BaseURL string              `json:"baseUrl"`
+				Paths   map[string][]string `json:"paths"`
+			} `json:"compilerOptions"`
+		}
+		if err := json.Unmarshal(stripJSONComments(source), &config); err != nil {
+			return nil
+		}
+		// Targets are relative to baseUrl, or to the tsconfig.json itself when it isn't set
+		base := filepath.Join(projectRoot, filepath.FromSlash(config.CompilerOptions.BaseURL))
+		paths := make(tsconfigPaths, 0, len(config.CompilerOptions.Paths))
+		for pattern, targets := range config.CompilerOptions.Paths {
+			path := tsconfigPath{pattern: pattern}
+			for _, target := range targets {
+				path.targets = append(path.targets, filepath.Join(base, filepath.FromSlash(target)))
+			}
+			paths = append(paths, path)
+		}
+		// Like TypeScript, prefer exact patterns, then the longest prefix
+		sort.SliceStable(paths, func(i, j int) bool {
+			return paths[i].prefixLength() > paths[j].prefixLength()
+		})
+		return paths
+	}
+	return nil
+}
+
+// tsconfig.json allows comments and trailing commas, which encoding/json doesn't
+func stripJSONComments(source []byte) []byte {
+	out := make([]byte, 0, len(source))
+	inString := false
+	for i := 0; i < len(source); i++ {
+		c := source[i]
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(source) {
+				i++
+				out = append(out, source[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(source) && source[i+1] == '/':
+			for i < len(source) && source[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '/' && i+1 < len(source) && source[i+1] == '*':
+			end := strings.Index(string(source[i+2:]), "*/")
+			if end == -1 {
+				return out
+			}
+			i += end + 3
+			continue
+		case c == '}' || c == ']':
+			// Drop a trailing comma before the closing bracket
+			trimmed := strings.TrimRight(string(out), " \t\r\n")
+			if strings.HasSuffix(trimmed, ",") {
+				out = append([]byte(trimmed[:len(trimmed)-1]), out[len(trimmed):]...)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
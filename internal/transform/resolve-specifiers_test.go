@@ -0,0 +1,117 @@
+package transform
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestResolveSpecifiers(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"tsconfig.json": `{
+	// Comments and trailing commas are allowed
+	"compilerOptions": {
+		"baseUrl": ".",
+		"paths": {
+			"@components/*": ["src/components/*"],
+			"*": ["src/vendor/*"],
+		},
+	},
+}`,
+		"src/pages/index.astro":          ``,
+		"src/components/Card.astro":      ``,
+		"src/components/Button/index.ts": ``,
+		"src/utils.ts":                   ``,
+		"src/vendor/local.js":            ``,
+	}
+	for name, content := range files {
+		filename := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		source      string
+		resolved    map[string]string
+		diagnostics []string
+	}{
+		{
+			name: "relative",
+			source: `---
+import Card from '../components/Card.astro';
+import Button from '../components/Button';
+import { format } from '../utils.js';
+import data from '../data.json?raw';
+---`,
+			resolved: map[string]string{
+				"../components/Card.astro": "src/components/Card.astro",
+				"../components/Button":     "src/components/Button/index.ts",
+				"../utils.js":              "src/utils.ts",
+			},
+			diagnostics: []string{"Could not resolve `../data.json?raw`"},
+		},
+		{
+			name: "paths",
+			source: `---
+import Card from '@components/Card.astro';
+import Missing from '@components/Missing.astro';
+import local from 'local';
+import react from 'react';
+---`,
+			resolved: map[string]string{
+				"@components/Card.astro": "src/components/Card.astro",
+				"local":                  "src/vendor/local.js",
+			},
+			diagnostics: []string{"Could not resolve `@components/Missing.astro`"},
+		},
+		{
+			name: "packages and types",
+			source: `---
+import { z } from 'zod';
+import type { Props } from '../types';
+---`,
+			resolved:    map[string]string{},
+			diagnostics: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ResolveSpecifiers(doc, TransformOptions{ProjectRoot: root, Filename: "src/pages/index.astro"})
+
+			resolved := make(map[string]string)
+			for specifier, filename := range doc.ResolvedSpecifiers {
+				relative, _ := filepath.Rel(root, filename)
+				resolved[specifier] = filepath.ToSlash(relative)
+			}
+			if diff := test_utils.ANSIDiff(tt.resolved, resolved); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+
+			diagnostics := make([]string, 0)
+			for _, diagnostic := range doc.Diagnostics {
+				if !strings.HasPrefix(tt.source[diagnostic.Range.Loc.Start:], "import") {
+					t.Errorf("wrong location %d for %q", diagnostic.Range.Loc.Start, diagnostic.Text)
+				}
+				diagnostics = append(diagnostics, diagnostic.Text)
+			}
+			if diff := test_utils.ANSIDiff(tt.diagnostics, diagnostics); diff != "" {
+				t.Error(fmt.Sprintf("mismatch (-want +got):\n%s", diff))
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package transform
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
 	// "strings"
@@ -30,218 +31,436 @@ outer:
 		if n.FirstChild == nil {
 			continue
 		}
-		p := css.NewParser(bytes.NewBufferString(n.FirstChild.Data), false)
-		out := ""
+		scoped, mappings := scopeCSS(n.FirstChild.Data, opts, false)
+		n.FirstChild.Data = scoped
+		n.FirstChild.SourceMappings = composeSourceMappings(n.FirstChild.SourceMappings, mappings)
+	}
+	return didScope
+}
+
+// Ties an offset in the output of scopeCSS to an offset in its input
+type cssMapping struct {
+	generated int
+	original  int
+}
+
+// Maps the scoped stylesheet back through the mappings a preprocessor left on
+// it. Every rule and declaration starts a new mapping, which points at the
+// offset in the preprocessed code that the start of its input came from.
+func composeSourceMappings(preprocessed []astro.SourceMapping, mappings []cssMapping) []astro.SourceMapping {
+	if len(preprocessed) == 0 {
+		return nil
+	}
+	composed := make([]astro.SourceMapping, 0, len(mappings))
+	for _, m := range mappings {
+		if last := len(composed) - 1; last >= 0 && composed[last].Generated >= m.generated {
+			continue
+		}
+		composed = append(composed, astro.SourceMapping{Generated: m.generated, Original: preprocessedOffset(preprocessed, m.original)})
+	}
+	return composed
+}
 
-		isKeyframes := false    // if we’re inside @keyframes, there’s nothing to scope
-		keyframeCurlyCount := 0 // keep track of open "{"s inside @keyframes
-		declaration := ""
+// Tracks where each part of a stylesheet came from while it's scoped. The CSS
+// parser doesn't report offsets, so the text of each part is searched for
+// right after the end of the one before it.
+type cssMapper struct {
+	source   string
+	cursor   int
+	mappings []cssMapping
+}
 
-	walk:
-		for {
-			gt, _, data := p.Next()
+// Map the output at generated to the next occurrence of text in the source
+func (m *cssMapper) mark(generated int, text string) {
+	if start := m.skip(text); start != -1 {
+		m.mappings = append(m.mappings, cssMapping{generated: generated, original: start})
+	}
+}
 
+// Move past the next occurrence of text in the source, and return where it starts
+func (m *cssMapper) skip(text string) int {
+	if strings.TrimSpace(text) == "" {
+		return -1
+	}
+	i := strings.Index(m.source[m.cursor:], text)
+	if i == -1 {
+		return -1
+	}
+	start := m.cursor + i
+	m.cursor = start + len(text)
+	return start
+}
+
+// Scope a stylesheet. Inside of a `:global { ... }` block, isGlobalBlock is
+// true and selectors are only scoped when wrapped in `:local()`. Along with
+// the scoped stylesheet, the start of each rule and declaration is mapped
+// back to the source.
+func scopeCSS(source string, opts TransformOptions, isGlobalBlock bool) (string, []cssMapping) {
+	extracted, globalBlocks := extractGlobalBlocks(source)
+	p := css.NewParser(bytes.NewBufferString(extracted), false)
+	out := ""
+	m := &cssMapper{source: source, mappings: make([]cssMapping, 0)}
+	nextGlobalBlock := 0
+
+	isKeyframes := false    // if we’re inside @keyframes, there’s nothing to scope
+	keyframeCurlyCount := 0 // keep track of open "{"s inside @keyframes
+	declaration := ""
+
+walk:
+	for {
+		gt, _, data := p.Next()
+
+		switch gt {
+		case css.ErrorGrammar:
+			if len(string(data)) > 0 {
+				m.mark(len(out), string(data))
+				out += string(data) // this will happen for invalid or unexpected CSS. Try and retain output as much as possible without throwing
+			} else {
+				break walk // an unrecoverable error occurred, or the end has been reached
+			}
+		case css.CommentGrammar:
+			m.mark(len(out), string(data))
+			out += string(data)
+		case css.EndAtRuleGrammar,
+			css.EndRulesetGrammar:
+			m.mark(len(out), "}")
+			out += "}"
+		case
+			css.BeginAtRuleGrammar,
+			css.BeginRulesetGrammar,
+			css.DeclarationGrammar,
+			css.QualifiedRuleGrammar:
+
+			nextValues := p.Values()
+			switch {
+			case gt == css.BeginAtRuleGrammar || gt == css.DeclarationGrammar:
+				m.mark(len(out), string(data))
+			case len(nextValues) > 0:
+				m.mark(len(out), string(nextValues[0].Data))
+				nextValues = nextValues[1:]
+			}
+			for _, val := range nextValues {
+				m.skip(string(val.Data))
+			}
+
+			// prelude
 			switch gt {
-			case css.ErrorGrammar:
-				if len(string(data)) > 0 {
-					out += string(data) // this will happen for invalid or unexpected CSS. Try and retain output as much as possible without throwing
-				} else {
-					break walk // an unrecoverable error occurred, or the end has been reached
-				}
-			case css.CommentGrammar:
+			case css.AtRuleGrammar,
+				css.BeginAtRuleGrammar:
 				out += string(data)
-			case css.EndAtRuleGrammar,
-				css.EndRulesetGrammar:
-				out += "}"
-			case
-				css.BeginAtRuleGrammar,
-				css.BeginRulesetGrammar,
-				css.DeclarationGrammar,
-				css.QualifiedRuleGrammar:
-
-				// prelude
-				switch gt {
-				case css.AtRuleGrammar,
-					css.BeginAtRuleGrammar:
-					out += string(data)
-					if string(data) == "@keyframes" {
-						isKeyframes = true
-						keyframeCurlyCount = 0
-					}
-				case css.DeclarationGrammar:
-					out += string(data) + ":"
-					declaration = string(data)
-				default:
+				if string(data) == "@keyframes" {
+					isKeyframes = true
+					keyframeCurlyCount = 0
 				}
+			case css.DeclarationGrammar:
+				out += string(data) + ":"
+				declaration = string(data)
+			default:
+			}
 
-				// main selector
-				parenCount := 0          // keeps track of open parens. scoping can’t happen inside parens (:not(), :where(), etc.)
-				isBracket := false       // keeps track of attr brackets (can’t nest like parens, so it’s simply ”open”/“close”)
-				isGlobal := false        // keeps track of :global() function (no scope, and omit from output)
-				isElement := true        // keeps track of base element selectors (e.g. body, h1). Elements must be assumed ("true") until ".", "#", etc. are encountered
-				isGlobalElement := false // keeps track of <body>, <html>, and other protected elements (isElement will always be true as well)
-				isPseudoState := false   // keeps track of pseudo state/element context (i.e. ensures :hover or ::before don’t get scoped). This is "false" until ":" is encountered
-				nextValues := p.Values()
-				for n, val := range nextValues {
-					strVal := string(val.Data)
-
-					// if inside @keyframes, don’t transform what’s there
-					if isKeyframes {
-						if strVal == "{" {
-							keyframeCurlyCount++
-						} else if strVal == "}" {
-							keyframeCurlyCount--
-						}
+			// main selector
+			parenCount := 0           // keeps track of open parens. scoping can’t happen inside parens (:not(), :where(), etc.)
+			globalParen := 0          // paren depth of the open :global(), or 0 if there is none
+			localParen := 0           // paren depth of the open :local(), or 0 if there is none
+			isBracket := false        // keeps track of attr brackets (can’t nest like parens, so it’s simply ”open”/“close”)
+			isGlobal := isGlobalBlock // keeps track of :global() function and :global {} blocks (no scope, and omit from output)
+			isElement := true         // keeps track of base element selectors (e.g. body, h1). Elements must be assumed ("true") until ".", "#", etc. are encountered
+			isGlobalElement := false  // keeps track of <body>, <html>, and other protected elements (isElement will always be true as well)
+			isPseudoState := false    // keeps track of pseudo state/element context (i.e. ensures :hover or ::before don’t get scoped). This is "false" until ":" is encountered
+			nextValues = p.Values()
+			for n, val := range nextValues {
+				strVal := string(val.Data)
 
-						// Inside of this case, we only want to break out when keyframeCurlyCount is -1
-						// since 0 is the default
-						if keyframeCurlyCount < 0 {
-							isKeyframes = false
-						}
-						out += strVal
-						continue
+				// if inside @keyframes, don’t transform what’s there
+				if isKeyframes {
+					if strVal == "{" {
+						keyframeCurlyCount++
+					} else if strVal == "}" {
+						keyframeCurlyCount--
 					}
 
-					switch strVal {
-					case ".",
-						"#":
-						isPseudoState = false
-						isElement = false
-						out += strVal
-					case ":":
-						isPseudoState = true
-						// look ahead to see if this is the start of ":global(".
-						// If so, omit from output and start global state
-						if len(nextValues) > n+1 && string(nextValues[n+1].Data) == "global(" {
-							isGlobal = true
-						} else {
-							// if not the start of ":global(", then include in output
-							out += strVal
-						}
-					case "global(":
-						parenCount++
-						// omit from output
-					case "(":
-						parenCount++
-						out += strVal
-						isElement = true
-						isPseudoState = false
-					case ")":
-						parenCount--
-						if !isGlobal || parenCount != 0 {
-							out += strVal // output only if this doesn’t close ":global("
-						}
-					case "[":
-						isBracket = true
-						isElement = false
-						isPseudoState = false
+					// Inside of this case, we only want to break out when keyframeCurlyCount is -1
+					// since 0 is the default
+					if keyframeCurlyCount < 0 {
+						isKeyframes = false
+					}
+					out += strVal
+					continue
+				}
 
-						// if there is no selector before an attribute selector and we're not in a delcaration, assume "*"
-						if n == 0 && declaration == "" {
-							out += scopeRule("", opts)
-						}
+				switch strVal {
+				case ".",
+					"#":
+					isPseudoState = false
+					isElement = false
+					out += strVal
+				case ":":
+					isPseudoState = true
+					// look ahead to see if this is the start of ":global(" or ":local(".
+					// If so, omit from output
+					if len(nextValues) > n+1 && (string(nextValues[n+1].Data) == "global(" || string(nextValues[n+1].Data) == "local(") {
+						break
+					}
+					// if not the start of ":global(", then include in output
+					out += strVal
+				case "global(":
+					// omit from output and start global state
+					parenCount++
+					globalParen = parenCount
+					isGlobal = true
+				case "local(":
+					// omit from output and force scoping, even inside of a :global {} block
+					parenCount++
+					localParen = parenCount
+					isGlobal = false
+					isElement = true
+					isPseudoState = false
+				case "(":
+					parenCount++
+					out += strVal
+					isElement = true
+					isPseudoState = false
+				case ")":
+					switch parenCount {
+					case globalParen:
+						globalParen = 0 // omit from output, this closes ":global("
+					case localParen:
+						localParen = 0 // omit from output, this closes ":local("
+					default:
 						out += strVal
-					case "]":
-						isBracket = false
+					}
+					parenCount--
+				case "[":
+					isBracket = true
+					isElement = false
+					isPseudoState = false
+
+					// if there is no selector before an attribute selector and we're not in a delcaration, assume "*"
+					if n == 0 && declaration == "" && !isGlobal {
+						out += scopeRule("", opts)
+					}
+					out += strVal
+				case "]":
+					isBracket = false
+					out += strVal
+				case "{":
+					if isKeyframes {
+						keyframeCurlyCount++
+					}
+					isElement = true
+					isPseudoState = false
+					out += strVal
+				case "}":
+					if isKeyframes {
+						keyframeCurlyCount--
+					}
+					if keyframeCurlyCount == 0 {
+						isKeyframes = false
+					}
+					out += strVal
+				case "*":
+					if isScopeable(parenCount, localParen) && !isGlobal {
+						out += scopeRule("", opts) // turns "*" into ".astro-XXXXXX" rather than "*.astro-XXXXXX"
+					} else {
 						out += strVal
-					case "{":
-						if isKeyframes {
-							keyframeCurlyCount++
-						}
+					}
+				default:
+					// handle IDs with parens attached
+					if strings.Contains(strVal, "(") {
+						parenCount++ // if new paren opened, count it
 						isElement = true
 						isPseudoState = false
-						out += strVal
-					case "}":
-						if isKeyframes {
-							keyframeCurlyCount--
-						}
-						if keyframeCurlyCount == 0 {
-							isKeyframes = false
-						}
-						out += strVal
-					case "*":
-						if parenCount == 0 && !isGlobal {
-							out += scopeRule("", opts) // turns "*" into ".astro-XXXXXX" rather than "*.astro-XXXXXX"
-						} else {
-							out += strVal
-						}
-					default:
-						// handle IDs with parens attached
-						if strings.Contains(strVal, "(") {
-							parenCount++ // if new paren opened, count it
-							isElement = true
-							isPseudoState = false
-						}
+					}
 
-						// if this is an element, check if it’s <body>, etc.
-						if isElement && globalElement(strVal) {
-							isGlobalElement = true
-						}
+					// if this is an element, check if it’s <body>, etc.
+					if isElement && globalElement(strVal) {
+						isGlobalElement = true
+					}
 
-						// whitespace tokens are used to reset chained classes and functions
-						if val.TokenType == css.WhitespaceToken {
-							// important: global elements like <body> may have classes that should not be scoped
-							if isElement && isGlobalElement {
-								isGlobalElement = false
-							}
-
-							// important: :global() might be chained (:global().some-class)
-							// so keep it active until whitespace is reached after final paren
-							if isGlobal && parenCount == 0 {
-								isGlobal = false
-							}
+					// whitespace tokens are used to reset chained classes and functions
+					if val.TokenType == css.WhitespaceToken {
+						// important: global elements like <body> may have classes that should not be scoped
+						if isElement && isGlobalElement {
+							isGlobalElement = false
 						}
 
-						// scope class
-						isCssSelector := (gt == css.BeginRulesetGrammar || gt == css.QualifiedRuleGrammar) && (val.TokenType == css.IdentToken || val.TokenType == css.HashToken)
-						if isCssSelector && // don’t scope @media and other non-class specifiers
-							!isPseudoState && // don’t scope pseudostates
-							!isGlobal && // don’t scope in :global() scope
-							!isGlobalElement &&
-							!isBracket && // don’t scope within element brackets
-							parenCount == 0 { // don’t scope within parens like :not()
-							out += scopeRule(strVal, opts)
-						} else {
-							// otherwise, append output
-							out += strVal
+						// important: :global() and :local() might be chained (:global().some-class)
+						// so keep them active until whitespace is reached after final paren
+						if parenCount == 0 {
+							isGlobal = isGlobalBlock
 						}
+					}
 
-						// reset state
-						isElement = true
-						isPseudoState = false
-						declaration = ""
+					// scope class
+					isCssSelector := (gt == css.BeginRulesetGrammar || gt == css.QualifiedRuleGrammar) && (val.TokenType == css.IdentToken || val.TokenType == css.HashToken)
+					if isCssSelector && // don’t scope @media and other non-class specifiers
+						!isPseudoState && // don’t scope pseudostates
+						!isGlobal && // don’t scope in :global() scope
+						!isGlobalElement &&
+						!isBracket && // don’t scope within element brackets
+						isScopeable(parenCount, localParen) { // don’t scope within parens like :not()
+						out += scopeRule(strVal, opts)
+					} else {
+						// otherwise, append output
+						out += strVal
 					}
+
+					// reset state
+					isElement = true
+					isPseudoState = false
+					declaration = ""
 				}
+			}
 
-				// generate tail of next rule
-				switch gt {
-				case css.BeginAtRuleGrammar,
-					css.BeginRulesetGrammar:
-					out += "{"
-				case css.DeclarationGrammar,
-					css.EndRulesetGrammar,
-					css.EndAtRuleGrammar:
-					out += ";"
-				case css.QualifiedRuleGrammar:
-					out += ","
+			// generate tail of next rule
+			switch gt {
+			case css.BeginAtRuleGrammar,
+				css.BeginRulesetGrammar:
+				out += "{"
+			case css.DeclarationGrammar,
+				css.EndRulesetGrammar,
+				css.EndAtRuleGrammar:
+				out += ";"
+			case css.QualifiedRuleGrammar:
+				out += ","
+			}
+		default:
+			strData := string(data)
+			// Scope the contents of a `:global { ... }` block in its place
+			if gt == css.AtRuleGrammar && nextGlobalBlock < len(globalBlocks) && strData == globalBlockPlaceholder(nextGlobalBlock) {
+				block := globalBlocks[nextGlobalBlock]
+				scoped, mappings := scopeCSS(block.css, opts, true)
+				for _, mapping := range mappings {
+					m.mappings = append(m.mappings, cssMapping{generated: len(out) + mapping.generated, original: block.start + mapping.original})
 				}
-			default:
-				strData := string(data)
-				out += strData
-				for _, val := range p.Values() {
-					strVal := string(val.Data)
-					// handle CSS variables
-					if strings.HasPrefix(strData, "--") {
-						out += ":"
-					}
-					out += strVal
+				out += scoped
+				m.cursor = block.end
+				nextGlobalBlock++
+				continue
+			}
+			m.mark(len(out), strData)
+			for _, val := range p.Values() {
+				m.skip(string(val.Data))
+			}
+			out += strData
+			for _, val := range p.Values() {
+				strVal := string(val.Data)
+				// handle CSS variables
+				if strings.HasPrefix(strData, "--") {
+					out += ":"
 				}
-				out += ";"
+				out += strVal
 			}
+			out += ";"
 		}
-		n.FirstChild.Data = out
 	}
-	return didScope
+
+	// Blocks the parser didn't reach in their place are spliced in afterwards, without mappings
+	for i := nextGlobalBlock; i < len(globalBlocks); i++ {
+		scoped, _ := scopeCSS(globalBlocks[i].css, opts, true)
+		out = strings.Replace(out, globalBlockPlaceholder(i)+";", scoped, 1)
+	}
+	return out, m.mappings
+}
+
+// The contents of a `:global { ... }` block, and where the block is in the stylesheet
+type globalBlock struct {
+	css string
+	// The offset right after the `{`
+	start int
+	// The offset right after the `}`
+	end int
+}
+
+// The CSS parser doesn't understand nested rules, so every `:global { ... }`
+// block is swapped out for a placeholder at-rule. The contents of each block
+// are returned so they can be scoped separately.
+func extractGlobalBlocks(source string) (string, []globalBlock) {
+	l := css.NewLexer(bytes.NewBufferString(source))
+	blocks := make([]globalBlock, 0)
+	out := ""
+	i := 0              // current offset in source
+	prev := 0           // end of the last block that was replaced
+	start := 0          // start of a potential `:global` prelude
+	isRuleStart := true // whether the next token can start a new rule
+	isColon := false    // found ":" at the start of a rule
+	isPrelude := false  // found ":global" at the start of a rule
+
+	for {
+		token, value := l.Next()
+		if token == css.ErrorToken {
+			break
+		}
+		if token == css.WhitespaceToken || token == css.CommentToken {
+			isColon = false
+			i += len(value)
+			continue
+		}
+
+		switch {
+		case isRuleStart && token == css.ColonToken:
+			isColon = true
+			isRuleStart = false
+			start = i
+			i += len(value)
+			continue
+		case isColon && token == css.IdentToken && strings.ToLower(string(value)) == "global":
+			isColon = false
+			isPrelude = true
+			i += len(value)
+			continue
+		case isPrelude && token == css.LeftBraceToken:
+			// consume the block until its braces are balanced
+			blockStart := i + len(value)
+			blockEnd := -1
+			i = blockStart
+			depth := 1
+			for depth > 0 {
+				next, nextValue := l.Next()
+				if next == css.ErrorToken {
+					break
+				}
+				if next == css.LeftBraceToken {
+					depth++
+				} else if next == css.RightBraceToken {
+					depth--
+				}
+				if depth == 0 {
+					blockEnd = i
+				}
+				i += len(nextValue)
+			}
+			if blockEnd == -1 {
+				blockEnd = i
+			}
+			out += source[prev:start] + globalBlockPlaceholder(len(blocks)) + ";"
+			blocks = append(blocks, globalBlock{css: source[blockStart:blockEnd], start: blockStart, end: i})
+			prev = i
+			isColon = false
+			isPrelude = false
+			isRuleStart = true
+			continue
+		}
+
+		isColon = false
+		isPrelude = false
+		isRuleStart = token == css.LeftBraceToken || token == css.RightBraceToken || token == css.SemicolonToken
+		i += len(value)
+	}
+
+	if len(blocks) == 0 {
+		return source, blocks
+	}
+	return out + source[prev:], blocks
+}
+
+func globalBlockPlaceholder(i int) string {
+	return fmt.Sprintf("@astro-global-%d", i)
+}
+
+// Selectors can only be scoped outside of parens, or directly inside of :local()
+func isScopeable(parenCount int, localParen int) bool {
+	return parenCount == 0 || parenCount == localParen
 }
 
 // Turn ".foo" into ".foo.astro-XXXXXX"
@@ -7,6 +7,7 @@ import (
 
 	astro "github.com/withastro/compiler/internal"
 	"github.com/withastro/compiler/internal/test_utils"
+	"golang.org/x/net/html/atom"
 )
 
 func TestScopeStyle(t *testing.T) {
@@ -127,6 +128,51 @@ func TestScopeStyle(t *testing.T) {
 			source: ".class:global(.bar){}",
 			want:   ".class.astro-XXXXXX.bar{}", // technically this may be incorrect, but would require a lookahead to fix
 		},
+		{
+			name:   "local",
+			source: ":local(.class) h1{}",
+			want:   ".class.astro-XXXXXX h1.astro-XXXXXX{}",
+		},
+		{
+			name:   "local inside global",
+			source: ":global(.a :local(.b)) .c{}",
+			want:   ".a .b.astro-XXXXXX .c.astro-XXXXXX{}",
+		},
+		{
+			name:   "global block",
+			source: ":global{.a{}h1 p{}}.b{}",
+			want:   ".a{}h1 p{}.b.astro-XXXXXX{}",
+		},
+		{
+			name:   "global block with whitespace",
+			source: ":global {\n  .a, .b {}\n  *{}\n  [hidden]{}\n}\n.c{}",
+			want:   ".a,.b{}*{}[hidden]{}.c.astro-XXXXXX{}",
+		},
+		{
+			name:   "global block inside media query",
+			source: "@media screen{:global{.a{}}.b{}}",
+			want:   "@media screen{.a{}.b.astro-XXXXXX{}}",
+		},
+		{
+			name:   "global block with media query",
+			source: ":global{@media screen{.a{}}}",
+			want:   "@media screen{.a{}}",
+		},
+		{
+			name:   "global block with local",
+			source: ":global{.a :local(.b){}:local(h1){}}",
+			want:   ".a .b.astro-XXXXXX{}h1.astro-XXXXXX{}",
+		},
+		{
+			name:   "global block with keyframes",
+			source: ":global{@keyframes fade{from{opacity:0;}}.a{}}",
+			want:   "@keyframes fade{from{opacity:0;}}.a{}",
+		},
+		{
+			name:   "global block is not a selector",
+			source: ".a :global{}",
+			want:   ".a.astro-XXXXXX :global{}",
+		},
 		{
 			name:   "chained :not()",
 			source: ".class:not(.is-active):not(.is-disabled){}",
@@ -260,3 +306,42 @@ func TestScopeStyle(t *testing.T) {
 		})
 	}
 }
+
+func TestScopeStyleSourceMappings(t *testing.T) {
+	css := ".a { color: red }\n:global { .b { color: blue } }\n.c { color: green }"
+	style := &astro.Node{Type: astro.ElementNode, DataAtom: atom.Style, Data: "style"}
+	text := &astro.Node{Type: astro.TextNode, Data: css}
+	style.AppendChild(text)
+	// As if a preprocessor returned the code as it was
+	text.SourceMappings = []astro.SourceMapping{{Generated: 0, Original: 0}}
+	ScopeStyle([]*astro.Node{style}, TransformOptions{Scope: "XXXXXX"})
+
+	// Scoped code mapped to the code it should point at
+	want := map[string]string{
+		".a.astro-XXXXXX": ".a",
+		"color:red":       "color: red",
+		".b{":             ".b",
+		"color:blue":      "color: blue",
+		".c.astro-XXXXXX": ".c",
+		"color:green":     "color: green",
+	}
+	for generated, original := range want {
+		offset := strings.Index(text.Data, generated)
+		if offset == -1 {
+			t.Fatalf("%q is missing from %q", generated, text.Data)
+		}
+		var mapping *astro.SourceMapping
+		for i := range text.SourceMappings {
+			if text.SourceMappings[i].Generated == offset {
+				mapping = &text.SourceMappings[i]
+			}
+		}
+		if mapping == nil {
+			t.Errorf("no mapping for %q", generated)
+			continue
+		}
+		if !strings.HasPrefix(css[mapping.Original:], original) {
+			t.Errorf("%q maps to %q, expected %q", generated, css[mapping.Original:], original)
+		}
+	}
+}
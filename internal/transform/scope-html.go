@@ -2,6 +2,7 @@ package transform
 
 import (
 	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/loc"
 )
 
 func ScopeElement(n *astro.Node, opts TransformOptions) {
@@ -61,6 +62,7 @@ func injectScopedClass(n *astro.Node, opts TransformOptions) {
 				return
 			case astro.ExpressionAttribute:
 				// as an expression
+				attr.ValSource = &loc.Span{Start: 1, End: 1 + len(attr.Val)}
 				attr.Val = "(" + attr.Val + `) + " astro-` + opts.Scope + `"`
 				n.Attr[i] = attr
 				return
@@ -73,3 +75,13 @@ func injectScopedClass(n *astro.Node, opts TransformOptions) {
 		Val: "astro-" + opts.Scope,
 	})
 }
+
+// SourceValue returns the part of an attribute's value which was copied from
+// the source, when transform has wrapped it in code of its own. ValLoc still
+// points at the start of it.
+func SourceValue(attr astro.Attribute) (string, bool) {
+	if attr.ValSource == nil {
+		return "", false
+	}
+	return attr.Val[attr.ValSource.Start:attr.ValSource.End], true
+}
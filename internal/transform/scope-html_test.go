@@ -87,3 +87,29 @@ func TestScopeHTML(t *testing.T) {
 		})
 	}
 }
+
+func TestSourceValue(t *testing.T) {
+	source := `<div class={ active ? "a" : "b" } />`
+	nodes, err := astro.ParseFragment(strings.NewReader(source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := nodes[0].Attr[0].ValLoc
+	ScopeElement(nodes[0], TransformOptions{Scope: "XXXXXX"})
+	attr := nodes[0].Attr[0]
+	if attr.ValLoc != before {
+		t.Errorf("expected the value location to stay at %d, got %d", before.Start, attr.ValLoc.Start)
+	}
+	expr, ok := SourceValue(attr)
+	if !ok {
+		t.Fatalf("expected a scoped class expression in %q", attr.Val)
+	}
+	if got := source[attr.ValLoc.Start : attr.ValLoc.Start+len(expr)]; got != expr {
+		t.Errorf("expected %q at the value location, got %q", expr, got)
+	}
+
+	// Code that only looks like a wrapped expression is left alone
+	if _, ok := SourceValue(astro.Attribute{Key: "class", Val: `(a) + " astro-XXXXXX"`, Type: astro.ExpressionAttribute}); ok {
+		t.Error("expected only values wrapped by transform to have a source value")
+	}
+}
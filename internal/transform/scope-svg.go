@@ -0,0 +1,60 @@
+package transform
+
+import (
+	"strings"
+
+	astro "github.com/withastro/compiler/internal"
+	a "golang.org/x/net/html/atom"
+)
+
+// <style> tags inside of an <svg> are not hoisted by ExtractStyles, so they
+// would apply to the entire page. Scope them to their own <svg> instead,
+// leaving the <style> in place.
+//
+// Each <svg> is scoped with a hash of its styles, so that icons which reuse
+// the same class names (like `.st0`) don't conflict with one another. A
+// nested <svg> is part of the outermost one, which is scoped as a whole.
+func ScopeSVGStyles(doc *astro.Node, opts TransformOptions) {
+	var f func(*astro.Node)
+	f = func(n *astro.Node) {
+		if n.Type == astro.ElementNode && n.DataAtom == a.Svg {
+			scopeSVG(n, opts)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+}
+
+func scopeSVG(n *astro.Node, opts TransformOptions) {
+	styles := make([]*astro.Node, 0)
+	walk(n, func(c *astro.Node) {
+		if c.Type == astro.ElementNode && c.DataAtom == a.Style && !hasTruthyAttr(c, "global") {
+			styles = append(styles, c)
+		}
+	})
+	if len(styles) == 0 {
+		return
+	}
+
+	var source strings.Builder
+	for _, style := range styles {
+		if style.FirstChild != nil {
+			source.WriteString(style.FirstChild.Data)
+		}
+	}
+	svgOpts := opts
+	svgOpts.Scope = astro.HashFromSource(source.String())
+
+	for _, style := range styles {
+		if style.FirstChild != nil {
+			style.FirstChild.Data, _ = scopeCSS(style.FirstChild.Data, svgOpts, false)
+		}
+	}
+	// Foreign elements keep their adjusted names and attributes, we only append to `class`
+	walk(n, func(c *astro.Node) {
+		ScopeElement(c, svgOpts)
+	})
+}
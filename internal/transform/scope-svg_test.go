@@ -0,0 +1,67 @@
+package transform
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"golang.org/x/net/html/atom"
+)
+
+func TestScopeSVGStyles(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "basic",
+			source: `<svg viewBox="0 0 1 1"><style>.st0{fill:red}</style><path class="st0" /></svg>`,
+			want:   `<svg viewBox="0 0 1 1" class="astro-I6A4WIBM"><style>.st0.astro-I6A4WIBM{fill:red;}</style><path class="st0 astro-I6A4WIBM"></path></svg>`,
+		},
+		{
+			name:   "foreign attributes",
+			source: `<svg><style>linearGradient{}</style><linearGradient gradientUnits="userSpaceOnUse" /></svg>`,
+			want:   `<svg class="astro-3NMIHQTZ"><style>linearGradient.astro-3NMIHQTZ{}</style><linearGradient gradientUnits="userSpaceOnUse" class="astro-3NMIHQTZ"></linearGradient></svg>`,
+		},
+		{
+			name:   "nested",
+			source: `<svg><style>.a{}</style><svg><style>.b{}</style><path class="b" /></svg></svg>`,
+			want:   `<svg class="astro-DGBTYYCJ"><style>.a.astro-DGBTYYCJ{}</style><svg class="astro-DGBTYYCJ"><style>.b.astro-DGBTYYCJ{}</style><path class="b astro-DGBTYYCJ"></path></svg></svg>`,
+		},
+		{
+			name:   "styles in defs",
+			source: `<svg><defs><style>.st0{fill:red}</style></defs><path class="st0" /></svg>`,
+			want:   `<svg class="astro-I6A4WIBM"><defs class="astro-I6A4WIBM"><style>.st0.astro-I6A4WIBM{fill:red;}</style></defs><path class="st0 astro-I6A4WIBM"></path></svg>`,
+		},
+		{
+			name:   "global",
+			source: `<svg><style global>.st0{fill:red}</style><path class="st0" /></svg>`,
+			want:   `<svg><style global>.st0{fill:red}</style><path class="st0"></path></svg>`,
+		},
+		{
+			name:   "no styles",
+			source: `<svg><path class="st0" /></svg>`,
+			want:   `<svg><path class="st0"></path></svg>`,
+		},
+	}
+	var b strings.Builder
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b.Reset()
+			nodes, err := astro.ParseFragment(strings.NewReader(tt.source), &astro.Node{Type: astro.ElementNode, DataAtom: atom.Body, Data: atom.Body.String()})
+			if err != nil {
+				t.Error(err)
+			}
+			doc := &astro.Node{Type: astro.DocumentNode}
+			doc.AppendChild(nodes[0])
+			ScopeSVGStyles(doc, TransformOptions{})
+			astro.PrintToSource(&b, nodes[0])
+			got := b.String()
+			if tt.want != got {
+				t.Error(fmt.Sprintf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.want, got))
+			}
+		})
+	}
+}
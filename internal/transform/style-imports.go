@@ -0,0 +1,239 @@
+package transform
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tdewolff/parse/css"
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/loc"
+)
+
+// Record every `@import` rule inside of the document's <style> tags.
+// If opts.ResolveStyleImports is set, local imports are removed from the CSS
+// so the printer can turn them into JS imports that the bundler will follow.
+func ExtractStyleImports(doc *astro.Node, opts TransformOptions) {
+	for _, n := range doc.Styles {
+		if n.FirstChild == nil {
+			continue
+		}
+		text := n.FirstChild.Data
+		isGlobal := hasTruthyAttr(n, "global")
+
+		out := ""
+		prev := 0
+		removed := make([]loc.Span, 0)
+		for _, rule := range findImportRules(text) {
+			imported := astro.StyleImport{
+				Specifier: rule.specifier,
+				Media:     rule.media,
+				Loc:       textLoc(n.FirstChild, rule.start),
+			}
+			// Imports with a media query can't be expressed as a JS import, so leave them alone
+			if opts.ResolveStyleImports && rule.media == "" && isLocalStyleImport(rule.specifier) {
+				imported.Resolved = true
+				imported.Scoped = opts.ScopeStyleImports && !isGlobal
+				out += text[prev:rule.start]
+				prev = rule.end
+				removed = append(removed, loc.Span{Start: rule.start, End: rule.end})
+			}
+			doc.StyleImports = append(doc.StyleImports, imported)
+		}
+		if prev > 0 {
+			n.FirstChild.Data = out + text[prev:]
+			n.FirstChild.SourceMappings = cutSourceMappings(n.FirstChild.SourceMappings, removed)
+		}
+	}
+	// doc.Styles isn't in source order, but imports must keep their authored order
+	sort.SliceStable(doc.StyleImports, func(i, j int) bool {
+		return doc.StyleImports[i].Loc.Start < doc.StyleImports[j].Loc.Start
+	})
+}
+
+// Returns where offset in a text node is in the source. Preprocessed text
+// points back at the source through its input source map, or at the start
+// of the text when the map doesn't lead there.
+func textLoc(text *astro.Node, offset int) loc.Loc {
+	if len(text.Loc) == 0 {
+		return loc.Loc{}
+	}
+	if text.InputSourceMap == nil {
+		return loc.Loc{Start: text.Loc[0].Start + offset}
+	}
+	if original, ok := text.InputSourceMap.OriginalLoc(preprocessedOffset(text.SourceMappings, offset)); ok {
+		return original
+	}
+	return text.Loc[0]
+}
+
+// Moves the mappings of a text to where they are after the removed spans were
+// cut out of it. Mappings inside of a removed span are dropped, and the text
+// after each span gets a mapping of its own.
+func cutSourceMappings(mappings []astro.SourceMapping, removed []loc.Span) []astro.SourceMapping {
+	if len(mappings) == 0 {
+		return mappings
+	}
+	cut := make([]astro.SourceMapping, 0, len(mappings)+len(removed))
+	add := func(offset int, shift int) {
+		if last := len(cut) - 1; last >= 0 && cut[last].Generated >= offset-shift {
+			return
+		}
+		cut = append(cut, astro.SourceMapping{Generated: offset - shift, Original: preprocessedOffset(mappings, offset)})
+	}
+	shift := 0
+	i := 0
+	for _, mapping := range mappings {
+		for i < len(removed) && removed[i].End <= mapping.Generated {
+			shift += removed[i].End - removed[i].Start
+			add(removed[i].End, shift)
+			i++
+		}
+		if i < len(removed) && removed[i].Start <= mapping.Generated {
+			continue
+		}
+		add(mapping.Generated, shift)
+	}
+	for ; i < len(removed); i++ {
+		shift += removed[i].End - removed[i].Start
+		add(removed[i].End, shift)
+	}
+	return cut
+}
+
+type importRule struct {
+	specifier string
+	media     string
+	start     int
+	end       int
+}
+
+func findImportRules(text string) []importRule {
+	l := css.NewLexer(bytes.NewBufferString(text))
+	rules := make([]importRule, 0)
+	i := 0
+	depth := 0
+
+	for {
+		token, value := l.Next()
+		if token == css.ErrorToken {
+			return rules
+		}
+		switch token {
+		case css.LeftBraceToken:
+			depth++
+		case css.RightBraceToken:
+			depth--
+		}
+
+		// `@import` is only valid at the top level of a stylesheet
+		if token != css.AtKeywordToken || depth != 0 || strings.ToLower(string(value)) != "@import" {
+			i += len(value)
+			continue
+		}
+
+		rule := importRule{start: i}
+		i += len(value)
+		media := ""
+		isURLFunction := false
+	rule:
+		for {
+			next, nextValue := l.Next()
+			if next == css.ErrorToken {
+				break rule
+			}
+			i += len(nextValue)
+			switch {
+			case next == css.SemicolonToken:
+				break rule
+			case next == css.WhitespaceToken || next == css.CommentToken:
+				// Comments aren't part of the media query, but still separate its tokens
+				if rule.specifier != "" {
+					media += " "
+				}
+			case rule.specifier == "" && next == css.StringToken:
+				rule.specifier = decodeCSSEscapes(trimQuotes(string(nextValue)))
+			case rule.specifier == "" && next == css.URLToken:
+				rule.specifier = decodeCSSEscapes(trimQuotes(strings.TrimSpace(string(nextValue[4 : len(nextValue)-1]))))
+			case rule.specifier == "" && next == css.FunctionToken && strings.ToLower(string(nextValue)) == "url(":
+				isURLFunction = true
+			case isURLFunction && next == css.RightParenthesisToken:
+				isURLFunction = false
+			default:
+				media += string(nextValue)
+			}
+		}
+		rule.end = i
+		rule.media = strings.Join(strings.Fields(media), " ")
+		rules = append(rules, rule)
+	}
+}
+
+func trimQuotes(str string) string {
+	if len(str) >= 2 && (str[0] == '"' || str[0] == '\'') && str[len(str)-1] == str[0] {
+		return str[1 : len(str)-1]
+	}
+	return str
+}
+
+// Replaces the escapes in a CSS string or URL with the characters they stand
+// for: `\` followed by up to six hex digits and an optional whitespace, an
+// escaped line break, which is removed, or any other escaped character.
+func decodeCSSEscapes(str string) string {
+	if !strings.Contains(str, "\\") {
+		return str
+	}
+	var b strings.Builder
+	for i := 0; i < len(str); i++ {
+		if str[i] != '\\' || i+1 == len(str) {
+			b.WriteByte(str[i])
+			continue
+		}
+		i++
+		end := i
+		for end < len(str) && end-i < 6 && isHexDigit(str[end]) {
+			end++
+		}
+		switch {
+		case end > i:
+			code, _ := strconv.ParseUint(str[i:end], 16, 32)
+			if code == 0 || code > unicode.MaxRune || (code >= 0xD800 && code <= 0xDFFF) {
+				code = unicode.ReplacementChar
+			}
+			b.WriteRune(rune(code))
+			// A single whitespace ends the escape
+			if end < len(str) && (str[end] == ' ' || str[end] == '\t' || str[end] == '\n') {
+				end++
+			} else if end+1 < len(str) && str[end] == '\r' && str[end+1] == '\n' {
+				end += 2
+			} else if end < len(str) && str[end] == '\r' {
+				end++
+			}
+			i = end - 1
+		case str[i] == '\n':
+		case str[i] == '\r':
+			if i+1 < len(str) && str[i+1] == '\n' {
+				i++
+			}
+		default:
+			b.WriteByte(str[i])
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// Remote and inline stylesheets can't be resolved by the bundler
+func isLocalStyleImport(specifier string) bool {
+	for _, prefix := range []string{"http://", "https://", "//", "data:"} {
+		if strings.HasPrefix(specifier, prefix) {
+			return false
+		}
+	}
+	return specifier != ""
+}
@@ -0,0 +1,155 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+
+	astro "github.com/withastro/compiler/internal"
+	"github.com/withastro/compiler/internal/loc"
+	"github.com/withastro/compiler/internal/sourcemap"
+	"github.com/withastro/compiler/internal/test_utils"
+)
+
+func TestExtractStyleImports(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		opts    TransformOptions
+		want    []astro.StyleImport
+		wantCSS string
+	}{
+		{
+			name:    "plain",
+			source:  `<style>@import "./a.css";.a{}</style>`,
+			want:    []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: 7}}},
+			wantCSS: `@import "./a.css";.a{}`,
+		},
+		{
+			name:    "url",
+			source:  `<style>@import url('./a.css'); @import url(./b.css);</style>`,
+			want:    []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: 7}}, {Specifier: "./b.css", Loc: loc.Loc{Start: 31}}},
+			wantCSS: `@import url('./a.css'); @import url(./b.css);`,
+		},
+		{
+			name:    "media",
+			source:  `<style>@import "./print.css" print;</style>`,
+			want:    []astro.StyleImport{{Specifier: "./print.css", Media: "print", Loc: loc.Loc{Start: 7}}},
+			wantCSS: `@import "./print.css" print;`,
+		},
+		{
+			name:    "resolved",
+			source:  `<style>@import "./a.css";.a{}</style>`,
+			opts:    TransformOptions{ResolveStyleImports: true},
+			want:    []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: 7}, Resolved: true}},
+			wantCSS: `.a{}`,
+		},
+		{
+			name:    "resolved scoped",
+			source:  `<style>@import "./a.css";</style><style global>@import "./b.css";</style>`,
+			opts:    TransformOptions{ResolveStyleImports: true, ScopeStyleImports: true},
+			want:    []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: 7}, Resolved: true, Scoped: true}, {Specifier: "./b.css", Loc: loc.Loc{Start: 47}, Resolved: true}},
+			wantCSS: ``,
+		},
+		{
+			name:    "remote is not resolved",
+			source:  `<style>@import "https://fonts.googleapis.com/css?family=Inter";</style>`,
+			opts:    TransformOptions{ResolveStyleImports: true},
+			want:    []astro.StyleImport{{Specifier: "https://fonts.googleapis.com/css?family=Inter", Loc: loc.Loc{Start: 7}}},
+			wantCSS: `@import "https://fonts.googleapis.com/css?family=Inter";`,
+		},
+		{
+			name:    "media is not resolved",
+			source:  `<style>@import "./print.css" print;</style>`,
+			opts:    TransformOptions{ResolveStyleImports: true},
+			want:    []astro.StyleImport{{Specifier: "./print.css", Media: "print", Loc: loc.Loc{Start: 7}}},
+			wantCSS: `@import "./print.css" print;`,
+		},
+		{
+			name:    "escapes",
+			source:  `<style>@import "./a\"b\62 .css"; @import url(./c\(d\).css);</style>`,
+			want:    []astro.StyleImport{{Specifier: `./a"bb.css`, Loc: loc.Loc{Start: 7}}, {Specifier: "./c(d).css", Loc: loc.Loc{Start: 33}}},
+			wantCSS: `@import "./a\"b\62 .css"; @import url(./c\(d\).css);`,
+		},
+		{
+			name:    "comments are not media",
+			source:  `<style>@import "./a.css" /* theme */; @import "./b.css" screen /* wide */ and (min-width: 1px);</style>`,
+			opts:    TransformOptions{ResolveStyleImports: true},
+			want:    []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: 7}, Resolved: true}, {Specifier: "./b.css", Media: "screen and (min-width: 1px)", Loc: loc.Loc{Start: 38}}},
+			wantCSS: ` @import "./b.css" screen /* wide */ and (min-width: 1px);`,
+		},
+		{
+			name:    "nested is ignored",
+			source:  `<style>.a{}@media print{@import "./a.css";}</style>`,
+			want:    nil,
+			wantCSS: `.a{}@media print{@import "./a.css";}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := astro.Parse(strings.NewReader(tt.source))
+			if err != nil {
+				t.Error(err)
+			}
+			ExtractStyles(doc)
+			ExtractStyleImports(doc, tt.opts)
+			if diff := test_utils.ANSIDiff(tt.want, doc.StyleImports); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+			if got := doc.Styles[0].FirstChild.Data; got != tt.wantCSS {
+				t.Errorf("\nFAIL: %s\n  want: %s\n  got:  %s", tt.name, tt.wantCSS, got)
+			}
+		})
+	}
+}
+
+func TestExtractStyleImportsSourceMappings(t *testing.T) {
+	source := `<style>.x{}@import "./a.css";.y{}@import "./b.css";.z{}</style>`
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExtractStyles(doc)
+	text := doc.Styles[0].FirstChild
+	// As if a preprocessor returned the text as it was, with a mapping at
+	// each rule and the first import
+	code := text.Data
+	for _, part := range []string{".x", `@import "./a.css"`, ".y", ".z"} {
+		offset := strings.Index(code, part)
+		text.SourceMappings = append(text.SourceMappings, astro.SourceMapping{Generated: offset, Original: offset})
+	}
+	ExtractStyleImports(doc, TransformOptions{ResolveStyleImports: true})
+
+	// The text after each removed import is mapped to where it was copied from
+	want := []astro.SourceMapping{
+		{Generated: strings.Index(text.Data, ".x"), Original: strings.Index(code, ".x")},
+		{Generated: strings.Index(text.Data, ".y"), Original: strings.Index(code, ".y")},
+		{Generated: strings.Index(text.Data, ".z"), Original: strings.Index(code, ".z")},
+	}
+	if diff := test_utils.ANSIDiff(want, text.SourceMappings); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExtractStyleImportsPreprocessedLoc(t *testing.T) {
+	source := "<style lang=\"scss\">\n$c: red;\n@import \"./a.css\";\n.a { color: $c; }\n</style>"
+	doc, err := astro.Parse(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ExtractStyles(doc)
+	// As if Sass compiled the style, mapping the import back to where it was written
+	code := "@import \"./a.css\";\n.a {\n  color: red;\n}"
+	ApplyPreprocessed(doc.Styles[0], code, &sourcemap.SourceMap{
+		Sources: []string{"style.scss"},
+		Mappings: []sourcemap.Mapping{
+			{GeneratedLine: 0, GeneratedColumn: 0, OriginalLine: 2, OriginalColumn: 0, NameIndex: -1},
+			{GeneratedLine: 1, GeneratedColumn: 0, OriginalLine: 3, OriginalColumn: 0, NameIndex: -1},
+		},
+	})
+	ExtractStyleImports(doc, TransformOptions{})
+
+	want := []astro.StyleImport{{Specifier: "./a.css", Loc: loc.Loc{Start: strings.Index(source, "@import")}}}
+	if diff := test_utils.ANSIDiff(want, doc.StyleImports); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
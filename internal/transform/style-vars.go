@@ -0,0 +1,50 @@
+package transform
+
+import (
+	astro "github.com/withastro/compiler/internal"
+)
+
+// Scoped <style define:vars> rules target the current component instance
+// through this attribute, rather than applying to the whole page.
+const StyleVarsAttribute = "data-astro-vars"
+
+// The printer declares this identifier once per component instance
+const StyleVarsID = "$$styleVarsId"
+
+// Whether a <style> has `define:vars` that should be scoped per instance
+func IsScopedStyleVars(n *astro.Node) bool {
+	return HasAttr(n, "define:vars") && !hasTruthyAttr(n, "global")
+}
+
+func HasScopedStyleVars(doc *astro.Node) bool {
+	for _, style := range doc.Styles {
+		if IsScopedStyleVars(style) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add the per-instance id to the root elements of the component. Components,
+// Fragments and expressions are not elements themselves, so we look through
+// them to the elements they render.
+func AddStyleVarsID(doc *astro.Node) {
+	var f func(*astro.Node)
+	f = func(n *astro.Node) {
+		if n.Type == astro.ElementNode && !IsImplictNode(n) && !n.Component && !n.Fragment && !n.Expression {
+			// <body> and friends can't be scoped, but their children can
+			if _, noScope := NeverScopedElements[n.Data]; !noScope {
+				n.Attr = append(n.Attr, astro.Attribute{
+					Key:  StyleVarsAttribute,
+					Val:  StyleVarsID,
+					Type: astro.ExpressionAttribute,
+				})
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(doc)
+}
@@ -20,9 +20,30 @@ type TransformOptions struct {
 	ProjectRoot      string
 	PreprocessStyle  interface{}
 	StaticExtraction bool
+	// Import hoisted inline scripts as modules of their own, like
+	// StaticExtraction does for styles, instead of inlining them in $$metadata.
+	// The bundler plugin must resolve these imports to an empty module during
+	// SSR, so that client code doesn't run on the server.
+	StaticScriptExtraction bool
+	// Hoist subtrees without anything dynamic in them into constants, so
+	// their HTML isn't joined again on every render
+	StaticSubtrees bool
+	// Turn local `@import` rules in <style> into JS imports
+	ResolveStyleImports bool
+	// Ask the bundler to scope files imported by a scoped <style>
+	ScopeStyleImports bool
+	// Scope <style> inside of <svg> to the <svg> itself
+	ScopeSVGStyles bool
+	// "analyze" skips code generation and only returns metadata
+	Mode string
+	// Check that local import specifiers point at files that exist
+	ResolveSpecifiers bool
+	// The name of the component function, instead of one derived from Filename
+	ComponentName string
 }
 
 func Transform(doc *astro.Node, opts TransformOptions) *astro.Node {
+	ExtractStyleImports(doc, opts)
 	shouldScope := len(doc.Styles) > 0 && ScopeStyle(doc.Styles, opts)
 	walk(doc, func(n *astro.Node) {
 		ExtractScript(doc, n)
@@ -32,11 +53,27 @@ func Transform(doc *astro.Node, opts TransformOptions) *astro.Node {
 		}
 	})
 
+	if opts.ScopeSVGStyles {
+		ScopeSVGStyles(doc, opts)
+	}
+	if shouldScope && HasScopedStyleVars(doc) {
+		AddStyleVarsID(doc)
+	}
+
 	// Important! Remove scripts from original location *after* walking the doc
 	for _, script := range doc.Scripts {
 		script.Parent.RemoveChild(script)
 	}
 
+	addRenderScopeDiagnostics(doc)
+	addDefaultExportDiagnostics(doc)
+	addStyleVarsDiagnostics(doc)
+	addUnresolvedComponentDiagnostics(doc)
+	addReservedNameDiagnostics(doc)
+	if opts.ResolveSpecifiers {
+		ResolveSpecifiers(doc, opts)
+	}
+
 	// Sometimes files have leading <script hoist> or <style>...
 	// Since we can't detect a "component-only" file until after `parse`, we need to handle
 	// them here. The component will be hoisted to the root of the document, `html` and `head` will be removed.
@@ -155,14 +192,14 @@ func AddComponentProps(doc *astro.Node, n *astro.Node) {
 				doc.HydratedComponents = append([]*astro.Node{n}, doc.HydratedComponents...)
 				pathAttr := astro.Attribute{
 					Key:  "client:component-path",
-					Val:  fmt.Sprintf("$$metadata.getPath(%s)", id),
+					Val:  fmt.Sprintf("%s.getPath(%s)", Metadata, id),
 					Type: astro.ExpressionAttribute,
 				}
 				n.Attr = append(n.Attr, pathAttr)
 
 				exportAttr := astro.Attribute{
 					Key:  "client:component-export",
-					Val:  fmt.Sprintf("$$metadata.getExport(%s)", id),
+					Val:  fmt.Sprintf("%s.getExport(%s)", Metadata, id),
 					Type: astro.ExpressionAttribute,
 				}
 				n.Attr = append(n.Attr, exportAttr)
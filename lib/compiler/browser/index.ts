@@ -5,8 +5,13 @@ export const transform: typeof types.transform = (input, options) => {
   return ensureServiceIsRunning().transform(input, options);
 };
 
+export const analyzeStyles: typeof types.analyzeStyles = (sources) => {
+  return ensureServiceIsRunning().analyzeStyles(sources);
+};
+
 interface Service {
   transform: typeof types.transform;
+  analyzeStyles: typeof types.analyzeStyles;
 }
 
 let initializePromise: Promise<void> | undefined;
@@ -52,7 +57,7 @@ const startRunningService = async (wasmURL: string) => {
   const wasm = await instantiateWASM(wasmURL, go.importObject);
   go.run(wasm.instance);
 
-  const apiKeys = new Set(['transform']);
+  const apiKeys = new Set(['transform', 'analyzeStyles']);
   const service: any = Object.create(null);
 
   for (const key of apiKeys.values()) {
@@ -63,5 +68,6 @@ const startRunningService = async (wasmURL: string) => {
 
   longLivedService = {
     transform: (input, options) => new Promise((resolve) => resolve(service.transform(input, options || {}))),
+    analyzeStyles: (sources) => new Promise((resolve) => resolve(service.analyzeStyles(sources))),
   };
 };
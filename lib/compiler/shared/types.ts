@@ -1,6 +1,6 @@
 export interface PreprocessorResult {
   code: string;
-  map?: string;
+  map?: string | Record<string, any>;
 }
 
 export interface TransformOptions {
@@ -13,12 +13,91 @@ export interface TransformOptions {
   projectRoot?: string;
   preprocessStyle?: (content: string, attrs: Record<string, string>) => Promise<PreprocessorResult>;
   experimentalStaticExtraction?: boolean;
+  /** Import hoisted inline scripts from `?astro&type=script&index=N` modules instead of inlining them */
+  experimentalStaticScriptExtraction?: boolean;
+  /** Render elements that have nothing dynamic in them once, into module-level constants */
+  experimentalStaticSubtrees?: boolean;
+  resolveStyleImports?: boolean;
+  scopeStyleImports?: boolean;
+  scopeSVGStyles?: boolean;
+  mode?: 'compile' | 'analyze';
+  resolveSpecifiers?: boolean;
+  /** The name of the component function. By default it's derived from `sourcefile`, like `$$UserCard` */
+  componentName?: string;
+}
+
+export interface StyleImport {
+  specifier: string;
+  media: string;
+  start: number;
+  resolved: boolean;
+}
+
+export interface ModuleImport {
+  specifier: string;
+  kind: 'import' | 'dynamic' | 'reexport';
+  start: number;
+  end: number;
+}
+
+export interface ImportBinding {
+  imported: string;
+  local: string;
+}
+
+export interface ImportAnalysis extends ModuleImport {
+  bindings: ImportBinding[];
+  /** The absolute path of the file, or an empty string if it wasn't resolved */
+  resolved: string;
+}
+
+export interface ExportAnalysis {
+  name: string;
+  start: number;
+}
+
+export interface ComponentUsage {
+  name: string;
+  start: number;
+  directives: string[];
+}
+
+export interface ComponentAnalysis {
+  imports: ImportAnalysis[];
+  exports: ExportAnalysis[];
+  components: ComponentUsage[];
+  slots: string[];
+  hasGetStaticPaths: boolean;
+}
+
+export interface Diagnostic {
+  severity: 'error' | 'warning';
+  text: string;
+  start: number;
+  end: number;
+}
+
+export interface HoistedScript {
+  type: 'inline' | 'remote';
+  src: string;
+  /** The `lang` attribute, `js` when there isn't one */
+  lang: string;
+  attrs: Record<string, string | boolean>;
+  code: string;
+  /** A source map from `code` back to the component, set when `sourcemap` is enabled */
+  map: string;
 }
 
 export interface TransformResult {
   css: string[];
   code: string;
   map: string;
+  styleImports: StyleImport[];
+  imports: ModuleImport[];
+  analysis: ComponentAnalysis;
+  /** Hoisted scripts, in the same order as `$$metadata.hoisted` */
+  scripts: HoistedScript[];
+  diagnostics: Diagnostic[];
 }
 
 // This function transforms a single JavaScript file. It can be used to minify
@@ -30,6 +109,32 @@ export interface TransformResult {
 // Works in browser: yes
 export declare function transform(input: string, options?: TransformOptions): Promise<TransformResult>;
 
+export interface StyleSource {
+  filename: string;
+  source: string;
+}
+
+export interface StyleLocation {
+  filename: string;
+  start: number;
+}
+
+export interface StyleAnalysis {
+  // Style blocks with identical content in more than one place
+  duplicateStyles: { hash: string; locations: StyleLocation[] }[];
+  // Unscoped selectors defined by more than one component
+  globalSelectors: { selector: string; components: string[] }[];
+  // Components that share a scope hash
+  scopeCollisions: { scope: string; components: string[] }[];
+}
+
+// This function looks at the styles of many components at once to report
+// problems that can't be seen one component at a time.
+//
+// Works in node: yes
+// Works in browser: yes
+export declare function analyzeStyles(sources: StyleSource[]): Promise<StyleAnalysis>;
+
 // This configures the browser-based version of astro. It is necessary to
 // call this first and wait for the returned promise to be resolved before
 // making other API calls when using astro in the browser.